@@ -16,6 +16,10 @@ type contextKey string
 
 const userContextKey = contextKey("user")
 
+// The tokenContextKey is the key for the plaintext authentication token that the user
+// was authenticated with.
+const tokenContextKey = contextKey("token")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we user our userContextKey constant as the
 // key.
@@ -35,3 +39,17 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetToken() method returns a new copy of the request with the plaintext
+// authentication token that the user presented added to the context.
+func (app *application) contextSetToken(r *http.Request, tokenPlaintext string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, tokenPlaintext)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() method returns the plaintext authentication token from the
+// request context, or the empty string for anonymous users.
+func (app *application) contextGetToken(r *http.Request) string {
+	tokenPlaintext, _ := r.Context().Value(tokenContextKey).(string)
+	return tokenPlaintext
+}
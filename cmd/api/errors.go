@@ -3,13 +3,44 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
+func (app *application) genreInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the genre is still used by one or more movies and cannot be deleted"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) duplicateReviewResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you have already reviewed this movie"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been changed since you last fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the Content-Type must be one of %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The importFailedResponse() method sends the per-line errors of a movie import which
+// was rejected.
+func (app *application) importFailedResponse(w http.ResponseWriter, r *http.Request, lines []importLineError) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, lines)
+}
+
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
 // The logError() method is a generic helper for logging an error message. Later in the
 // book we'll upgrade this to use structured logging, and record additional information
 // about the request including the HTTP method and URL
@@ -91,6 +122,13 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// The refresh token is sent in the request body rather than the Authorization header,
+// so unlike invalidAuthenticationTokenResponse() this doesn't set WWW-Authenticate.
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ynrfin/greenlight/internal/data"
+	"github.com/ynrfin/greenlight/internal/validator"
+)
+
+// A movieEncoder writes a stream of movies in one of the export formats. begin() is
+// called before the first movie and end() after the last one, even if there are no
+// movies.
+type movieEncoder interface {
+	begin() error
+	encode(movie *data.Movie) error
+	end() error
+}
+
+// csvMovieEncoder writes movies as CSV with a header row. The runtime uses the same
+// "<runtime> mins" format as the API, and the genres are a comma-separated list.
+type csvMovieEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvMovieEncoder) begin() error {
+	return e.w.Write([]string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count"})
+}
+
+func (e *csvMovieEncoder) encode(movie *data.Movie) error {
+	return e.w.Write([]string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		fmt.Sprintf("%d mins", movie.Runtime),
+		strings.Join(movie.Genres, ","),
+		strconv.FormatInt(int64(movie.Version), 10),
+		strconv.FormatFloat(movie.AverageRating, 'f', -1, 64),
+		strconv.FormatInt(int64(movie.RatingCount), 10),
+	})
+}
+
+func (e *csvMovieEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonMovieEncoder writes one JSON movie object per line.
+type ndjsonMovieEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonMovieEncoder) begin() error {
+	return nil
+}
+
+func (e *ndjsonMovieEncoder) encode(movie *data.Movie) error {
+	return e.enc.Encode(movie)
+}
+
+func (e *ndjsonMovieEncoder) end() error {
+	return nil
+}
+
+// jsonMovieEncoder writes the movies as a single JSON document in the same
+// {"movies": [...]} envelope as GET /v1/movies. The array is written one element at a
+// time, so it never has to be held in memory.
+type jsonMovieEncoder struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func (e *jsonMovieEncoder) begin() error {
+	_, err := io.WriteString(e.w, "{\"movies\":[\n")
+	return err
+}
+
+func (e *jsonMovieEncoder) encode(movie *data.Movie) error {
+	if e.count > 0 {
+		_, err := io.WriteString(e.w, ",")
+		if err != nil {
+			return err
+		}
+	}
+	e.count++
+
+	return e.enc.Encode(movie)
+}
+
+func (e *jsonMovieEncoder) end() error {
+	_, err := io.WriteString(e.w, "]}\n")
+	return err
+}
+
+// The exportMoviesHandler() streams every movie matching the same filters as
+// listMovieHandler() in CSV, NDJSON or JSON format. Unlike the other handlers it
+// doesn't use writeJSON(), which would need the whole response in memory. Instead
+// movies are written to a buffered writer as they are read from the database.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Format   string
+		Title    string
+		Genres   []string
+		Search   string
+		PersonID int64
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Format = app.readString(qs, "format", "json")
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Search = app.readString(qs, "q", "")
+	input.PersonID = int64(app.readInt(qs, "person", 0, v))
+
+	v.Check(validator.PermittedValue(input.Format, "csv", "ndjson", "json"), "format", "must be csv, ndjson or json")
+	v.Check(len(input.Search) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(input.PersonID >= 0, "person", "must not be negative")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	buf := bufio.NewWriter(w)
+
+	var encoder movieEncoder
+	var contentType string
+
+	switch input.Format {
+	case "csv":
+		encoder = &csvMovieEncoder{w: csv.NewWriter(buf)}
+		contentType = "text/csv"
+	case "ndjson":
+		encoder = &ndjsonMovieEncoder{enc: json.NewEncoder(buf)}
+		contentType = "application/x-ndjson"
+	default:
+		encoder = &jsonMovieEncoder{w: buf, enc: json.NewEncoder(buf)}
+		contentType = "application/json"
+	}
+
+	// The headers are only sent once the first batch of movies has been read, so that
+	// we can still send a normal error response if the query fails straight away.
+	started := false
+
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, input.Format))
+		w.WriteHeader(http.StatusOK)
+
+		return encoder.begin()
+	}
+
+	err := app.models.Movies.Export(r.Context(), input.Title, input.Genres, input.Search, input.PersonID, func(movie *data.Movie) error {
+		err := start()
+		if err != nil {
+			return err
+		}
+		return encoder.encode(movie)
+	})
+	if err == nil {
+		err = start()
+	}
+	if err == nil {
+		err = encoder.end()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+
+	if err != nil {
+		// Once the response has started we can't change the status code, so all we can
+		// do is log the error and stop. The client will see a truncated file.
+		if started {
+			app.logError(r, err)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+	}
+}
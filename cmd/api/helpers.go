@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +13,7 @@ import (
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/ynrfin/greenlight/internal/data"
 	"github.com/ynrfin/greenlight/internal/validator"
 )
 
@@ -60,14 +63,37 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 }
 
+// The readBool() helper reads a boolean value from the query string, accepting the
+// same values as strconv.ParseBool(), e.g. "true", "false", "1" and "0".
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
 // Retrieve  the "id" URL parameterfrom the current request context, then convert it to
 // an integer and return it. If the operation isn't successful, return 0 and and error mesage
 func (app *application) readIdParam(r *http.Request) (int64, error) {
+	return app.readNamedIdParam(r, "id")
+}
+
+// The readNamedIdParam() helper works like readIdParam(), but for routes with more than
+// one id in the URL, such as /v1/movies/:id/reviews/:review_id.
+func (app *application) readNamedIdParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
@@ -214,3 +240,87 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// The versionETag() helper returns the strong ETag for a version of a record, such as
+// "12-3" for version 3 of movie 12. Every edit increments the version, so it is what
+// If-Match preconditions are checked against.
+func versionETag(id int64, version int32) string {
+	return strconv.Quote(fmt.Sprintf("%d-%d", id, version))
+}
+
+// The movieETag() helper returns the ETag sent with a movie. It is the version ETag
+// with a hash of the movie's JSON encoding appended, like "12-3-5f0e...", because some
+// fields in the response change without the version being incremented, such as the
+// average rating when the movie is reviewed, or the genre names when a genre is
+// renamed. The hash lets If-None-Match tell that a cached copy is out of date, while
+// If-Match only compares the version part, so those changes don't stop the movie from
+// being edited.
+func movieETag(movie *data.Movie) (string, error) {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(js)
+	return strconv.Quote(fmt.Sprintf("%d-%d-%s", movie.ID, movie.Version, hex.EncodeToString(hash[:16]))), nil
+}
+
+// The etagListContains() helper reports whether a comma-separated list of ETags from an
+// If-None-Match header contains the given ETag, or is the "*" wildcard. RFC 7232 uses
+// weak comparison for If-None-Match, so weak ETags are compared by their opaque value
+// and W/"1" matches "1".
+func etagListContains(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// The versionListContains() helper reports whether a comma-separated list of ETags
+// from an If-Match header matches the given version of a record, or is the "*"
+// wildcard. Either the version ETag or a movieETag() for that version matches. RFC
+// 7232 uses strong comparison for If-Match, so a weak ETag never matches.
+func versionListContains(header string, id int64, version int32) bool {
+	etag := versionETag(id, version)
+	prefix := strings.TrimSuffix(etag, `"`) + "-"
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || candidate == etag || strings.HasPrefix(candidate, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// The ifMatch() helper checks the If-Match precondition of a request which changes a
+// record. It returns false if the client sent an If-Match header which doesn't match
+// the current version of the record, meaning that the client edited a stale copy and
+// the handler should send a 412 Precondition Failed response. Requests without the
+// header are allowed through, and rely on the version check in the database instead.
+func (app *application) ifMatch(r *http.Request, id int64, version int32) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	return versionListContains(header, id, version)
+}
+
+// The notModified() helper checks the If-None-Match header of a GET request. If the
+// client already has the current version of the record it sends a 304 Not Modified
+// response, with no body, and returns true.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagListContains(header, etag) {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+// TestReadJSON checks the error message that readJSON() returns for each kind of
+// problem with a request body.
+func TestReadJSON(t *testing.T) {
+	app := newTestApplication(t, memoryBackend)
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "valid", body: `{"title":"Moana","year":2016,"runtime":"107 mins"}`},
+		{name: "valid with whitespace", body: " {\"title\":\"Moana\"}\n\n"},
+		{name: "syntax error", body: `{"title":"Moana",}`, wantErr: "body contains badly-formed JSON (at character 18)"},
+		{name: "unexpected EOF", body: `{"title":"Moana"`, wantErr: "body contains badly formed JSON"},
+		{name: "wrong type for field", body: `{"year":"2016"}`, wantErr: `body contains incorrect JSON type for field "year"`},
+		{name: "wrong type for body", body: `["Moana"]`, wantErr: "body contains incorrect JSON type(at character 1)"},
+		{name: "empty", body: ``, wantErr: "body must not be empty"},
+		{name: "unknown key", body: `{"rating":5}`, wantErr: `body contains unknown key "rating"`},
+		{name: "too large", body: `{"title":"` + strings.Repeat("a", 1_048_576) + `"}`, wantErr: "body must not be larger than 1048576 bytes"},
+		{name: "multiple values", body: `{"title":"Moana"}{"title":"Up"}`, wantErr: "body must only contain a single JSON value"},
+		{name: "trailing garbage", body: `{"title":"Moana"} :~()`, wantErr: "body must only contain a single JSON value"},
+		{name: "invalid runtime", body: `{"runtime":"107 minutes"}`, wantErr: data.ErrInvalidRuntimeFormat.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var input struct {
+				Title   string       `json:"title"`
+				Year    int32        `json:"year"`
+				Runtime data.Runtime `json:"runtime"`
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+
+			err := app.readJSON(httptest.NewRecorder(), r, &input)
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %q; want none", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("got no error; want %q", tt.wantErr)
+			case tt.wantErr != "" && err.Error() != tt.wantErr:
+				t.Errorf("got error %q; want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("non-pointer destination", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("readJSON() didn't panic")
+			}
+		}()
+
+		var input struct{}
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+
+		app.readJSON(httptest.NewRecorder(), r, input)
+	})
+}
+
+// TestETagListContains checks the weak comparison used for If-None-Match.
+func TestETagListContains(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: `"abc"`, want: true},
+		{header: `"xyz", "abc"`, want: true},
+		{header: `*`, want: true},
+		{header: `W/"abc"`, want: true},
+		{header: `"xyz", W/"abc"`, want: true},
+		{header: `"xyz"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := etagListContains(tt.header, `"abc"`); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestVersionListContains checks the strong comparison used for If-Match, which
+// matches any ETag for version 3 of record 12, whatever body hash it carries.
+func TestVersionListContains(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: `"12-3"`, want: true},
+		{header: `"12-3-5f0e"`, want: true},
+		{header: `"12-2", "12-3-5f0e"`, want: true},
+		{header: `*`, want: true},
+		{header: `W/"12-3"`, want: false},
+		{header: `W/"12-3-5f0e"`, want: false},
+		{header: `"12-2"`, want: false},
+		{header: `"12-2-5f0e"`, want: false},
+		{header: `"12-30"`, want: false},
+		{header: `"112-3"`, want: false},
+		{header: `"7"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := versionListContains(tt.header, 12, 3); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
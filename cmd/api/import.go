@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ynrfin/greenlight/internal/data"
+	"github.com/ynrfin/greenlight/internal/validator"
+)
+
+const (
+	// The maximum size of an import request body, and the maximum number of movies
+	// that can be imported in one request.
+	maxImportBytes = 50 * 1_048_576
+	maxImportRows  = 10_000
+)
+
+// importRow holds a movie parsed from one line of an import, along with any errors
+// found while parsing it. Malformed rows couldn't be parsed into a movie at all, so
+// they aren't validated any further.
+type importRow struct {
+	line      int
+	movie     *data.Movie
+	v         *validator.Validator
+	malformed bool
+}
+
+// importLineError reports the problems with one line of an import.
+type importLineError struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+// The importMoviesHandler() imports a batch of movies from a CSV or NDJSON request
+// body. Every movie is validated before any are inserted, and if any line has a problem
+// nothing is imported and the errors for each line are returned instead. With the
+// dry_run=true query string parameter the movies are only validated.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var rows []*importRow
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "text/csv":
+		rows, err = parseCSVImport(r.Body)
+	case "application/x-ndjson":
+		rows, err = parseNDJSONImport(r.Body)
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "text/csv", "application/x-ndjson")
+		return
+	}
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(rows) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one movie"))
+		return
+	}
+
+	vocabulary, err := app.models.Genres.GetNames(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Validate every row, keeping any errors found while parsing it. AddError() doesn't
+	// overwrite existing errors, so a field which couldn't be parsed reports that
+	// rather than being "must be provided".
+	lineErrors := []importLineError{}
+	movies := make([]*data.Movie, len(rows))
+
+	for i, row := range rows {
+		if !row.malformed {
+			data.ValidateMovie(row.v, row.movie, vocabulary)
+		}
+
+		if !row.v.Valid() {
+			lineErrors = append(lineErrors, importLineError{Line: row.line, Errors: row.v.Errors})
+		}
+
+		movies[i] = row.movie
+	}
+
+	if len(lineErrors) > 0 {
+		app.importFailedResponse(w, r, lineErrors)
+		return
+	}
+
+	if dryRun {
+		err = app.writeJSON(w, http.StatusOK, envelope{"imported": 0, "valid": len(movies), "dry_run": true}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Movies.Import(r.Context(), movies, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownGenre):
+			// A genre was deleted from the vocabulary since we validated the movies.
+			v.AddError("genres", "must only contain known genres")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"imported": len(movies), "valid": len(movies), "dry_run": false}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// parseCSVImport() reads movies from CSV with a header row naming the columns. The
+// title, year, runtime and genres columns can be in any order, the runtime is in the
+// "<runtime> mins" format, and the genres are a comma-separated list, e.g.
+//
+//	title,year,runtime,genres
+//	Moana,2016,107 mins,"animation,adventure"
+func parseCSVImport(body io.Reader) ([]*importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, csvImportError(err)
+	}
+
+	columns := map[string]int{}
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if !validator.PermittedValue(name, "title", "year", "runtime", "genres") {
+			return nil, fmt.Errorf("body contains unknown column %q", name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("body contains duplicate column %q", name)
+		}
+		columns[name] = i
+	}
+
+	rows := []*importRow{}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, csvImportError(err)
+		}
+
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("body must not contain more than %d movies", maxImportRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := &importRow{line: line, movie: &data.Movie{}, v: validator.New()}
+
+		if len(record) != len(header) {
+			row.v.AddError("row", fmt.Sprintf("must have %d fields", len(header)))
+			row.malformed = true
+			rows = append(rows, row)
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row.movie.Title = field("title")
+
+		if year := field("year"); year != "" {
+			i, err := strconv.ParseInt(year, 10, 32)
+			if err != nil {
+				row.v.AddError("year", "must be an integer")
+			}
+			row.movie.Year = int32(i)
+		}
+
+		if runtime := field("runtime"); runtime != "" {
+			row.movie.Runtime, err = data.ParseRuntime(runtime)
+			if err != nil {
+				row.v.AddError("runtime", `must be in the format "<runtime> mins"`)
+			}
+		}
+
+		if genres := field("genres"); genres != "" {
+			for _, genre := range strings.Split(genres, ",") {
+				row.movie.Genres = append(row.movie.Genres, strings.TrimSpace(genre))
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// csvImportError() converts an error from reading the CSV into a message for the
+// client. CSV syntax errors can't be skipped over reliably, so they reject the whole
+// import rather than being reported against a single line.
+func csvImportError(err error) error {
+	var parseError *csv.ParseError
+
+	switch {
+	case errors.As(err, &parseError):
+		return fmt.Errorf("body contains badly-formed CSV (on line %d)", parseError.Line)
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	case err.Error() == "http: request body too large":
+		return fmt.Errorf("body must not be larger than %d bytes", maxImportBytes)
+	default:
+		return err
+	}
+}
+
+// parseNDJSONImport() reads movies from newline-delimited JSON, with one movie object in
+// the same format as the POST /v1/movies request body on each line. Blank lines are
+// skipped.
+func parseNDJSONImport(body io.Reader) ([]*importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	rows := []*importRow{}
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		js := bytes.TrimSpace(scanner.Bytes())
+		if len(js) == 0 {
+			continue
+		}
+
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("body must not contain more than %d movies", maxImportRows)
+		}
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		row := &importRow{line: line, movie: &data.Movie{}, v: validator.New()}
+
+		dec := json.NewDecoder(bytes.NewReader(js))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err == nil && dec.More() {
+			err = errors.New("line must only contain a single JSON value")
+		}
+		if err != nil {
+			if !addMovieDecodeError(row.v, err) {
+				row.v.AddError("json", "must be a single JSON object")
+			}
+			row.malformed = true
+			rows = append(rows, row)
+			continue
+		}
+
+		row.movie.Title = input.Title
+		row.movie.Year = input.Year
+		row.movie.Runtime = input.Runtime
+		row.movie.Genres = input.Genres
+
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		switch {
+		case errors.Is(err, bufio.ErrTooLong):
+			return nil, fmt.Errorf("line %d must not be larger than 1048576 bytes", line+1)
+		case err.Error() == "http: request body too large":
+			return nil, fmt.Errorf("body must not be larger than %d bytes", maxImportBytes)
+		default:
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// The purgeTrash() method starts a background job which permanently deletes movies
+// that have been in the trash for longer than the retention period. It runs once every
+// purge interval until the done channel is closed. The job is tracked by the
+// application WaitGroup, so a purge which is in progress when the server shuts down is
+// allowed to finish.
+func (app *application) purgeTrash(done <-chan struct{}) {
+	app.background(func() {
+		ticker := time.NewTicker(app.config.trash.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				purged, err := app.models.Movies.PurgeTrash(context.Background(), app.config.trash.retention)
+				if err != nil {
+					app.logger.PrintErr(err, nil)
+					continue
+				}
+
+				if purged > 0 {
+					app.logger.PrintInfo("purged movies from trash", map[string]string{
+						"count": strconv.FormatInt(purged, 10),
+					})
+				}
+			}
+		}
+	})
+}
+
+// The purgeTokens() method starts a background job which deletes expired tokens, and
+// refresh tokens which were used longer ago than the retention period, once every
+// cleanup interval until the done channel is closed.
+func (app *application) purgeTokens(done <-chan struct{}) {
+	app.background(func() {
+		ticker := time.NewTicker(app.config.auth.cleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				deleted, err := app.models.Tokens.DeleteExpired(context.Background(), app.config.auth.rotatedRetention)
+				if err != nil {
+					app.logger.PrintErr(err, nil)
+					continue
+				}
+
+				if deleted > 0 {
+					app.logger.PrintInfo("deleted expired tokens", map[string]string{
+						"count": strconv.FormatInt(deleted, 10),
+					})
+				}
+			}
+		}
+	})
+}
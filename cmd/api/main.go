@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"expvar"
 	"flag"
@@ -20,6 +21,7 @@ import (
 	"github.com/ynrfin/greenlight/internal/jsonlog"
 	"github.com/ynrfin/greenlight/internal/mailer"
 	"github.com/ynrfin/greenlight/internal/vcs"
+	"golang.org/x/time/rate"
 )
 
 // Declare a string containing the application version number. later in the book we'll
@@ -39,6 +41,12 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout time.Duration
+		// Importing, exporting and purging movies can take much longer than other
+		// queries, so they have their own timeouts.
+		importTimeout time.Duration
+		exportTimeout time.Duration
+		purgeTimeout  time.Duration
 	}
 
 	// Add a new limiter struct containing fields for the request-per-second and burst
@@ -48,6 +56,14 @@ type config struct {
 		rps     float64
 		burst   int
 		enabled bool
+		// The movie title suggestions endpoint is called on every keystroke by
+		// typeahead UIs, so it gets its own, more generous, limit.
+		suggestRps   float64
+		suggestBurst int
+		// Requests to resend an activation email are limited for each email address,
+		// as well as by client, so that they can't be used to flood someone's inbox.
+		activationInterval time.Duration
+		activationBurst    int
 	}
 	smtp struct {
 		host     string
@@ -60,6 +76,31 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+
+	// The secret used to sign keyset pagination cursors. If it isn't provided a
+	// random one is generated at startup, which means that cursors are invalidated
+	// whenever the server restarts.
+	cursor struct {
+		secret string
+	}
+
+	// Deleted movies are kept in the trash for the retention period, and a background
+	// job checks for movies to purge every purgeInterval.
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+
+	// Logging in issues a short-lived access token, and a long-lived refresh token
+	// which can be exchanged for a new pair of tokens without the user's password.
+	// Used refresh tokens are kept for rotatedRetention to detect their reuse, and a
+	// background job deletes them, along with expired tokens, every cleanupInterval.
+	auth struct {
+		accessTTL        time.Duration
+		refreshTTL       time.Duration
+		rotatedRetention time.Duration
+		cleanupInterval  time.Duration
+	}
 }
 
 var (
@@ -75,6 +116,18 @@ type application struct {
 	models data.Models
 	mailer mailer.Mailer
 	wg     sync.WaitGroup
+
+	// done is closed when the server shuts down, to stop the background jobs and the
+	// goroutines which clean up after the middleware.
+	done chan struct{}
+
+	// flushers tracks the goroutines which write out buffered changes when done is
+	// closed. They live as long as the server, so they are kept apart from wg, which
+	// can be waited on to let the short background tasks such as emails finish.
+	flushers sync.WaitGroup
+
+	// activationLimiters limits the activation emails sent to each email address.
+	activationLimiters *rateLimiters
 }
 
 func main() {
@@ -94,11 +147,19 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connection")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max open connection")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max open connection")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL query timeout")
+	flag.DurationVar(&cfg.db.importTimeout, "db-import-timeout", 30*time.Second, "PostgreSQL timeout for movie imports")
+	flag.DurationVar(&cfg.db.exportTimeout, "db-export-timeout", 10*time.Minute, "PostgreSQL timeout for movie exports")
+	flag.DurationVar(&cfg.db.purgeTimeout, "db-purge-timeout", 30*time.Second, "PostgreSQL timeout for purging the movie trash")
 
 	// Flag for rate limiter
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum request per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.suggestRps, "limiter-suggest-rps", 10, "Rate limiter maximum request per second for movie suggestions")
+	flag.IntVar(&cfg.limiter.suggestBurst, "limiter-suggest-burst", 20, "Rate limiter maximum burst for movie suggestions")
+	flag.DurationVar(&cfg.limiter.activationInterval, "limiter-activation-interval", 10*time.Minute, "Rate limiter interval between activation emails to an email address")
+	flag.IntVar(&cfg.limiter.activationBurst, "limiter-activation-burst", 3, "Rate limiter maximum burst of activation emails to an email address")
 
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
@@ -110,6 +171,16 @@ func main() {
 		return nil
 	})
 
+	flag.StringVar(&cfg.cursor.secret, "cursor-secret", "", "Secret key for signing pagination cursors")
+
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept before being purged")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often to purge expired movies from the trash")
+
+	flag.DurationVar(&cfg.auth.accessTTL, "auth-access-token-ttl", 15*time.Minute, "How long authentication tokens are valid for")
+	flag.DurationVar(&cfg.auth.refreshTTL, "auth-refresh-token-ttl", 30*24*time.Hour, "How long refresh tokens are valid for")
+	flag.DurationVar(&cfg.auth.rotatedRetention, "auth-rotated-token-retention", 7*24*time.Hour, "How long used refresh tokens are kept to detect their reuse")
+	flag.DurationVar(&cfg.auth.cleanupInterval, "auth-cleanup-interval", time.Hour, "How often to delete expired and used tokens")
+
 	displayVersion := flag.Bool("version", false, "Display version and exi")
 	flag.Parse()
 
@@ -156,11 +227,30 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	cursorKey := []byte(cfg.cursor.secret)
+	if len(cursorKey) == 0 {
+		cursorKey = make([]byte, 32)
+		_, err = rand.Read(cursorKey)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	done := make(chan struct{})
+
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModel(db),
+		models: data.NewModel(db, data.Timeouts{
+			Query:  cfg.db.queryTimeout,
+			Import: cfg.db.importTimeout,
+			Export: cfg.db.exportTimeout,
+			Purge:  cfg.db.purgeTimeout,
+		}, cursorKey),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		done:   done,
+
+		activationLimiters: newRateLimiters(rate.Every(cfg.limiter.activationInterval), cfg.limiter.activationBurst, done),
 	}
 
 	err = app.serve()
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
 	"expvar"
 	"fmt"
@@ -42,82 +44,137 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	// Define a client struct to hold the rate limiter and last seen time for each
-	// client
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+// The rateLimit() middleware applies the global per-client rate limit from the limiter
+// config. Requests for any of the exempt paths skip it, because those routes apply
+// their own limit with perClientRateLimit().
+func (app *application) rateLimit(next http.Handler, exemptPaths ...string) http.Handler {
+	limited := app.perClientRateLimit(app.config.limiter.rps, app.config.limiter.burst, next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if validator.PermittedValue(r.URL.Path, exemptPaths...) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
+
+// The perClientRateLimit() middleware limits each client IP address to the given
+// number of requests per second and burst.
+func (app *application) perClientRateLimit(rps float64, burst int, next http.Handler) http.Handler {
+	limiters := newRateLimiters(rate.Limit(rps), burst, app.done)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only carry out the check if rate limiting is enabled. The limiters are keyed
+		// by the client's IP address.
+		if app.config.limiter.enabled && !limiters.allow(realip.FromRequest(r)) {
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiters holds a rate limiter for each key, such as a client IP address, which
+// is created the first time that the key is seen.
+type rateLimiters struct {
+	limit rate.Limit
+	burst int
+
+	// Declare a mutex and a map to hold the clients' keys and rate limiters.
+	mu      sync.Mutex
+	clients map[string]*rateLimiterClient
+}
+
+// Define a client struct to hold the rate limiter and last seen time for each client.
+type rateLimiterClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiters() returns an empty set of rate limiters. The stale limiters are
+// cleaned up in the background until the done channel is closed.
+func newRateLimiters(limit rate.Limit, burst int, done <-chan struct{}) *rateLimiters {
+	l := &rateLimiters{
+		limit:   limit,
+		burst:   burst,
+		clients: make(map[string]*rateLimiterClient),
 	}
-	// Declare a mutex and a map to hold the client's IP addresses and rate limiters.
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
 
 	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute
+	// every minute, until the done channel is closed.
 	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
 		for {
-			time.Sleep(time.Minute)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
 
 			// Lock the mutex to prevent any rte limiter check from happening while
 			// the cleanup is taking place.
-			mu.Lock()
-
-			// loop through all clients. If they haven't been seen within the last three
-			// minutes, delete the corresponding entry from the map.
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+			l.mu.Lock()
+
+			// loop through all clients. If they haven't been seen for long enough that
+			// their limiter would be full again, delete the corresponding entry from
+			// the map. This is three minutes for limits of one request a minute or more.
+			for key, client := range l.clients {
+				if time.Since(client.lastSeen) > l.idleTime() {
+					delete(l.clients, key)
 				}
 			}
 
-			mu.Unlock()
+			l.mu.Unlock()
 		}
 	}()
 
-	// The function we are returning is a closure, which 'closes over' the limiter
-	// variable.
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Only carry out the check if rate limiting is enabled.
-		if app.config.limiter.enabled {
-			// Extract the client's IP address from the request
-			ip := realip.FromRequest(r)
-
-			// Lock the mutex to prevent this code from being executed concurrently
-			mu.Lock()
-
-			// Check to see if any IP address already exists in the map. If it doesn't, then
-			// Initialize a new rate limiter and add the IP address and limiter to the map.
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
-
-			// Update thelast seen time for the client.
-			clients[ip].lastSeen = time.Now()
+	return l
+}
 
-			// Call limiter .Allow() method on the rate limiter for the current IP address. If
-			// the request isn't allowed, unlock the mutex and send 429 just like before.
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
+// idleTime() returns how long a client must go unseen before its rate limiter is
+// deleted. Deleting it any earlier would let the client reset its limit by waiting.
+func (l *rateLimiters) idleTime() time.Duration {
+	idle := 3 * time.Minute
 
-			// Very importantly, unlock the mutex before calling the next handler in the
-			// chain. Notice that we DON'T use defer to unlock the mutex, as that would mean
-			// that the mutex isn't unlocked until all the handlers downstream of this
-			// middleware have also returned.
-			mu.Unlock()
+	if l.limit > 0 {
+		refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+		if refill > idle {
+			idle = refill
 		}
-		next.ServeHTTP(w, r)
-	})
+	}
+	return idle
+}
+
+// allow() reports whether a request for the key is allowed by its rate limiter.
+func (l *rateLimiters) allow(key string) bool {
+	// Lock the mutex to prevent this code from being executed concurrently.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Check to see if the key already exists in the map. If it doesn't, then initialize
+	// a new rate limiter and add the key and limiter to the map.
+	client, found := l.clients[key]
+	if !found {
+		client = &rateLimiterClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = client
+	}
+
+	// Update the last seen time for the client.
+	client.lastSeen = time.Now()
+
+	return client.limiter.Allow()
 }
 
 func (app *application) authenticate(next http.Handler) http.Handler {
+	// Record when each token was last used, for the session list. Writing to the
+	// database on every request would be wasteful, so the writes for each token are
+	// coalesced to at most one every lastUsedInterval, with the latest use in between
+	// written once the interval has passed.
+	lastUsed := app.newLastUsedWrites(lastUsedInterval)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
 		// caches that the response may vary based on the value of the Authorization
@@ -166,7 +223,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// again calling the invalidAuthenticationTokenResponse() helper if no
 		// matching record was found. IMPORTANT: notice that we are usirng
 		// ScopeAuthentication as the first parameter here.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -176,7 +233,10 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		app.touchToken(lastUsed, token)
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		next.ServeHTTP(w, r)
 
@@ -184,6 +244,131 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 }
 
+// lastUsedInterval is the longest that the last used time of a session can be out of
+// date by.
+const lastUsedInterval = time.Minute
+
+// lastUsedWrites keeps track of when the last used time of each token was last written
+// to the database, keyed by the token hash. Uses which come too soon after the last
+// write are held back as pending, and written later by the flush goroutine, so that
+// the session list catches up with them.
+type lastUsedWrites struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	written map[string]time.Time
+	pending map[string]time.Time
+}
+
+// newLastUsedWrites() returns an empty set of last used writes. A background goroutine
+// writes the pending last used times to the database once every minute until the done
+// channel is closed, when it writes all of the remaining ones before returning. It is
+// tracked by the application's flushers WaitGroup, so the server waits for the final
+// writes before exiting.
+func (app *application) newLastUsedWrites(interval time.Duration) *lastUsedWrites {
+	l := &lastUsedWrites{
+		interval: interval,
+		written:  make(map[string]time.Time),
+		pending:  make(map[string]time.Time),
+	}
+
+	app.flushers.Add(1)
+
+	go func() {
+		defer app.flushers.Done()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				app.writeLastUsed(l.flush(time.Time{}))
+				return
+			case now := <-ticker.C:
+				app.writeLastUsed(l.flush(now))
+			}
+		}
+	}()
+
+	return l
+}
+
+// due() reports whether the last used time for the token hash should be written at
+// now, and if so records the write. Otherwise the time is held back as pending.
+func (l *lastUsedWrites) due(hash string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if written, ok := l.written[hash]; ok && now.Sub(written) < l.interval {
+		l.pending[hash] = now
+		return false
+	}
+
+	l.written[hash] = now
+	delete(l.pending, hash)
+	return true
+}
+
+// flush() returns the pending last used times which are due to be written at now,
+// recording them as written, and forgets the writes which are too old to hold back
+// another one, so that the maps don't grow with every token that is ever used. A
+// zero now returns all of the pending times, for the final flush on shutdown.
+func (l *lastUsedWrites) flush(now time.Time) map[string]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	due := make(map[string]time.Time)
+
+	for hash, usedAt := range l.pending {
+		if now.IsZero() || now.Sub(l.written[hash]) >= l.interval {
+			due[hash] = usedAt
+			l.written[hash] = usedAt
+			delete(l.pending, hash)
+		}
+	}
+
+	if !now.IsZero() {
+		for hash, written := range l.written {
+			if _, ok := l.pending[hash]; !ok && now.Sub(written) >= l.interval {
+				delete(l.written, hash)
+			}
+		}
+	}
+
+	return due
+}
+
+// The writeLastUsed() helper writes the given last used times, keyed by token hash, to
+// the database. As in touchToken(), errors are just logged.
+func (app *application) writeLastUsed(times map[string]time.Time) {
+	for hash, usedAt := range times {
+		err := app.models.Tokens.UpdateLastUsed(context.Background(), []byte(hash), usedAt)
+		if err != nil {
+			app.logger.PrintErr(err, nil)
+		}
+	}
+}
+
+// The touchToken() helper updates the last used time of the token in the background,
+// if it is due to be written. The request doesn't wait for the write, and an error
+// only means that the session list is a little out of date, so it is just logged.
+func (app *application) touchToken(lastUsed *lastUsedWrites, tokenPlaintext string) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	now := time.Now()
+
+	if !lastUsed.due(string(hash[:]), now) {
+		return
+	}
+
+	app.background(func() {
+		err := app.models.Tokens.UpdateLastUsed(context.Background(), hash[:], now)
+		if err != nil {
+			app.logger.PrintErr(err, nil)
+		}
+	})
+}
+
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Use the contextGetUser() helper that we made earlier to retrieve the user
@@ -244,7 +429,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		user := app.contextGetUser(r)
 
 		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -279,7 +464,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match")
 						w.WriteHeader(http.StatusOK)
 					}
 					break
@@ -291,12 +476,17 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 }
 
 func (app *application) metrics(next http.Handler) http.Handler {
-	// initialize new expar variables when the middleware chain is first built.
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
-
-	totalResponseSentByStatus := expvar.NewMap("total_responses_sent_by_status")
+	// initialize new expar variables when the middleware chain is first built. The
+	// variables are reused if the chain is built again, as it is by the tests, because
+	// expvar panics if the same name is published twice.
+	totalRequestsReceived := expvarInt("total_requests_received")
+	totalResponsesSent := expvarInt("total_responses_sent")
+	totalProcessingTimeMicroseconds := expvarInt("total_processing_time_μs")
+
+	totalResponseSentByStatus, ok := expvar.Get("total_responses_sent_by_status").(*expvar.Map)
+	if !ok {
+		totalResponseSentByStatus = expvar.NewMap("total_responses_sent_by_status")
+	}
 
 	// the following code will be run for every request
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -310,3 +500,12 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalResponseSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
 	})
 }
+
+// expvarInt() returns the published expvar.Int with the given name, publishing a new
+// one if it doesn't exist yet.
+func expvarInt(name string) *expvar.Int {
+	if v, ok := expvar.Get(name).(*expvar.Int); ok {
+		return v
+	}
+	return expvar.NewInt(name)
+}
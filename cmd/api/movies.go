@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 
 	"github.com/ynrfin/greenlight/internal/data"
@@ -13,8 +14,11 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	// to keep things consitent with our other handlers, we'll define an input struct
 	// to hold the expected values frmo the request query string.
 	var input struct {
-		Title  string
-		Genres []string
+		Title    string
+		Genres   []string
+		Search   string
+		PersonID int64
+		Facets   []string
 		data.Filters
 	}
 
@@ -30,6 +34,17 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// Read the q query string value for full-text search over the movie titles. It
+	// accepts the websearch syntax, e.g. `"star wars" -clone`.
+	input.Search = app.readString(qs, "q", "")
+
+	// Read the ID of a person to list the filmography of, e.g. person=42. The default
+	// of zero doesn't filter by person.
+	input.PersonID = int64(app.readInt(qs, "person", 0, v))
+
+	// Read the facets to count alongside the results, e.g. facets=genres,year.
+	input.Facets = app.readCSV(qs, "facets", []string{})
+
 	// Get the page and page_size query string values as integers. Notice we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the argument here.
@@ -39,7 +54,15 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	// Extract the sort query string value, falling back to "id" if it is not provided
 	// by the client (which will imply an ascending sort on movie ID)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "relevance", "rating", "-id", "-title", "-year", "-runtime", "-relevance", "-rating"}
+
+	// Read the opaque cursor from a previous response, if any. When present it takes
+	// precedence over the page parameter.
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	v.Check(len(input.Search) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(input.PersonID >= 0, "person", "must not be negative")
+	data.ValidateFacets(v, input.Facets)
 
 	// Check i f the validator instance for any errors and use the failedValidationResponse()
 	// helper to send the  client a response if necessary.
@@ -48,14 +71,33 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Call the GetAll() method to retriev movies
-	movies, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	// Call the GetAll() method to retriev movies and the pagination metadata
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Search, input.PersonID, input.Filters)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			v.AddError("cursor", "invalid cursor for this sort order and filters")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies}, nil)
+	env := envelope{"movies": movies, "metadata": metadata}
+
+	// If the client asked for any facets, count them over the same filters and include
+	// them in the response.
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Movies.GetFacets(r.Context(), input.Title, input.Genres, input.Search, input.PersonID, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -78,6 +120,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	// Note that the mevoe variable contains a *pointer* to a Movie struct
@@ -88,19 +131,33 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Genres:  input.Genres,
 	}
 
+	// Fetch the genre vocabulary, so that we can check the movie genres against it.
+	vocabulary, err := app.models.Genres.GetNames(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, vocabulary); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Call the Insert() method on our movies model, passing in a pointer to the
 	// validated movie struct. This will create a record in the database and update the
-	// movie struct with the system-genrated information.
-	err = app.models.Movies.Insert(movie)
+	// movie struct with the system-genrated information. If a genre was deleted from
+	// the vocabulary since we validated the movie, Insert() returns ErrUnknownGenre.
+	err = app.models.Movies.Insert(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrUnknownGenre):
+			v.AddError("genres", "must only contain known genres")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -132,7 +189,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrorRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -143,7 +200,23 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Send a 304 Not Modified response if the client already has this version of the
+	// movie, otherwise include the ETag so that the client can make conditional
+	// requests later.
+	etag, err := movieETag(movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -159,7 +232,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Fetch existing ovie record from the database, sending a 404 Not Found
 	// response to the client if we could not find a matching record.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -170,57 +243,105 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Declare an input struct to hold the expected data from client
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	// If the client says which version of the movie it edited with an If-Match header,
+	// check it against the current version before going any further.
+	if !app.ifMatch(r, movie.ID, movie.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
 	}
 
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// The request body can be a JSON Merge Patch or a JSON Patch document, as well as
+	// the plain JSON object of fields to change. We pick between them using the
+	// Content-Type header, treating a missing header as plain JSON.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/merge-patch+json", "application/json-patch+json":
+		if !app.patchMovie(w, r, movie, mediaType) {
+			return
+		}
+
+	case "", "application/json":
+		// Declare an input struct to hold the expected data from client
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+
+		// Read the JSON request body data into the input struct.
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "application/json", "application/merge-patch+json", "application/json-patch+json")
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+	vocabulary, err := app.models.Genres.GetNames(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	// Validate the updated movie record, sending the clien ta 422 Unprocessable Entity
 	// response if any check fail.
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, vocabulary); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Pass the updated movie record to our new Update() method.
-	err = app.models.Movies.Update(movie)
+	// Pass the updated movie record to our new Update() method. If the movie changed
+	// after we read it, the client's If-Match precondition no longer holds either, so
+	// we send a 412 Precondition Failed response if it sent one, and a 409 Conflict
+	// otherwise.
+	err = app.models.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownGenre):
+			v.AddError("genres", "must only contain known genres")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 
 		}
+		return
+	}
+
+	etag, err := movieETag(movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -234,21 +355,149 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete the movie from the database, sendin a 404 Not Found response to the
-	// client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	// Fetch the movie to check the If-Match header against, and to get the version
+	// which the delete is conditional on.
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.ifMatch(r, movie.ID, movie.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// Move the movie to the trash, as long as it hasn't changed since we read it. If it
+	// has, the client's If-Match precondition no longer holds, so we send a 412
+	// Precondition Failed response if it sent one, and a 409 Conflict otherwise.
+	err = app.models.Movies.Delete(r.Context(), id, movie.Version, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Return a 200 OK status code along with success message. The movie is only moved
+	// to the trash, so it can still be restored until it is purged.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully moved to trash"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listMovieTrashHandler() returns a page of the movies which have been deleted but
+// not yet purged.
+func (app *application) listMovieTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafeList = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetTrash(r.Context(), input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The restoreMovieHandler() takes a movie back out of the trash and returns it.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.Restore(r.Context(), id, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The suggestMovieHandler() returns movie titles which fuzzily match the prefix query
+// string value, for use by typeahead search boxes.
+func (app *application) suggestMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Prefix string
+		Limit  int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Prefix = app.readString(qs, "prefix", "")
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	v.Check(input.Prefix != "", "prefix", "must be provided")
+	v.Check(len(input.Prefix) <= 100, "prefix", "must not be more than 100 bytes long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Suggest(r.Context(), input.Prefix, input.Limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	// Return a 200 OK status code along with success message
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
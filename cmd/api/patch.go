@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ynrfin/greenlight/internal/data"
+	"github.com/ynrfin/greenlight/internal/jsonpatch"
+	"github.com/ynrfin/greenlight/internal/validator"
+)
+
+// The patchMovie() helper applies a JSON Merge Patch (RFC 7396) or JSON Patch (RFC
+// 6902) request body to the JSON representation of a movie, and copies the patched
+// fields back into the movie struct. Only the title, year, runtime and genres can be
+// changed. If anything goes wrong it sends an error response itself and returns false.
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, movie *data.Movie, mediaType string) bool {
+	// Convert the movie to the generic JSON representation that the patch paths refer
+	// to, e.g. /genres/0.
+	js, err := json.Marshal(movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	var doc any
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	v := validator.New()
+
+	switch mediaType {
+	case "application/merge-patch+json":
+		var patch map[string]any
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return false
+		}
+
+		doc = jsonpatch.Merge(doc, patch)
+
+	default:
+		var operations []jsonpatch.Operation
+
+		err = app.readJSON(w, r, &operations)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return false
+		}
+
+		doc, err = jsonpatch.Apply(doc, operations)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.patchTestFailedResponse(w, r, err)
+			default:
+				v.AddError("patch", err.Error())
+				app.failedValidationResponse(w, r, v.Errors)
+			}
+			return false
+		}
+	}
+
+	js, err = json.Marshal(doc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	// Decode the patched document into a new movie, rejecting any members which
+	// aren't movie fields.
+	var patched data.Movie
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&patched)
+	if err != nil {
+		if !addMovieDecodeError(v, err) {
+			v.AddError("patch", "must produce a JSON object")
+		}
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	// The remaining fields are managed by the server, so the patch must leave them
+	// as they were.
+	v.Check(patched.ID == movie.ID, "id", "cannot be changed")
+	v.Check(patched.Version == movie.Version, "version", "cannot be changed")
+	v.Check(patched.AverageRating == movie.AverageRating, "average_rating", "cannot be changed")
+	v.Check(patched.RatingCount == movie.RatingCount, "rating_count", "cannot be changed")
+	v.Check(patched.Headline == movie.Headline, "headline", "cannot be changed")
+	v.Check(patched.DeletedAt == nil, "deleted_at", "cannot be changed")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+
+	return true
+}
+
+// The addMovieDecodeError() helper turns an error from decoding a JSON object into a
+// data.Movie into a validation error for the field concerned. It returns false if the
+// error isn't about a specific field.
+func addMovieDecodeError(v *validator.Validator, err error) bool {
+	var unmarshalTypeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+		v.AddError(unmarshalTypeError.Field, "has the wrong type")
+	case errors.Is(err, data.ErrInvalidRuntimeFormat):
+		v.AddError("runtime", `must be in the format "<runtime> mins"`)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		v.AddError(strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`), "is not a movie field")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+// routeUsers holds the users that the route tests make requests as, by name. Hashing
+// their passwords is slow, so they are only created once for each backend.
+type routeUsers struct {
+	tokens map[string]string
+	writer *data.User
+
+	// The session user's tokens can be revoked by the test cases, so the fixture gives
+	// them a new authentication token for each case.
+	session *data.User
+}
+
+func newRouteUsers(t *testing.T, app *testApplication) *routeUsers {
+	t.Helper()
+
+	u := &routeUsers{tokens: map[string]string{}}
+
+	_, u.tokens["reader"] = app.newTestUser(t, true, "movies:read")
+	_, u.tokens["inactive"] = app.newTestUser(t, false, "movies:read", "movies:write")
+	u.writer, u.tokens["writer"] = app.newTestUser(t, true, "movies:read", "movies:write", "movies:export", "genres:write")
+	u.session, _ = app.newTestUser(t, true)
+
+	return u
+}
+
+// routeFixture holds the records that the route tests refer to. Every test case gets
+// fresh records, so that cases which change or delete them don't affect the others.
+type routeFixture struct {
+	*routeUsers
+	sessionToken string
+	sessionID    int64
+
+	movie       *data.Movie
+	movieETag   string
+	trashed     *data.Movie
+	genre       *data.Genre
+	unusedGenre *data.Genre
+	person      *data.Person
+	review      *data.Review
+}
+
+func newRouteFixture(t *testing.T, app *testApplication, users *routeUsers) *routeFixture {
+	t.Helper()
+
+	ctx := context.Background()
+	f := &routeFixture{routeUsers: users}
+
+	token, err := app.models.Tokens.New(ctx, users.session.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.sessionToken = token.Plaintext
+	f.sessionID = token.ID
+
+	f.genre = app.newTestGenre(t)
+	f.unusedGenre = app.newTestGenre(t)
+	f.movie = app.newTestMovie(t, f.writer.ID, "Moana", f.genre.Name)
+	f.trashed = app.newTestMovie(t, f.writer.ID, "Black Panther", f.genre.Name)
+
+	err = app.models.Movies.Delete(ctx, f.trashed.ID, f.trashed.Version, f.writer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.person = &data.Person{Name: "Ron Clements"}
+	err = app.models.People.Insert(ctx, f.person)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.review = &data.Review{MovieID: f.movie.ID, UserID: f.writer.ID, Rating: 8}
+	err = app.models.Reviews.Insert(ctx, f.review)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = app.models.Watchlist.Put(ctx, f.writer.ID, &data.WatchlistEntry{MovieID: f.movie.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ETag covers the movie's rating, so it is taken after the review is added.
+	movie, err := app.models.Movies.Get(ctx, f.movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.movieETag, err = movieETag(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+// expand() replaces the {movie}, {movie_etag}, {trashed}, {genre}, {unused_genre},
+// {genre_name}, {session}, {person}, {review} and {writer_email} placeholders in s with
+// values from the fixture.
+func (f *routeFixture) expand(s string) string {
+	id := func(n int64) string { return strconv.FormatInt(n, 10) }
+
+	pairs := []string{
+		"{movie}", id(f.movie.ID),
+		"{movie_etag}", f.movieETag,
+		"{trashed}", id(f.trashed.ID),
+		"{genre}", id(f.genre.ID),
+		"{unused_genre}", id(f.unusedGenre.ID),
+		"{genre_name}", f.genre.Name,
+		"{session}", id(f.sessionID),
+		"{writer_email}", f.writer.Email,
+		"{person}", id(f.person.ID),
+		"{review}", id(f.review.ID),
+	}
+
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// TestRoutes sends a request to every route in routes.go, checking the status code for
+// anonymous, inactive, unprivileged and privileged users where it matters. String
+// bodies are sent as they are, after expanding the fixture placeholders.
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   string
+		body   string
+		header map[string]string
+		want   int
+	}{
+		{name: "healthcheck", method: "GET", path: "/v1/healthcheck", want: http.StatusOK},
+		{name: "healthcheck wrong method", method: "PUT", path: "/v1/healthcheck", want: http.StatusMethodNotAllowed},
+		{name: "unknown route", method: "GET", path: "/v1/unknown", want: http.StatusNotFound},
+		{name: "metrics", method: "GET", path: "/debug/vars", want: http.StatusOK},
+
+		{name: "list movies anonymous", method: "GET", path: "/v1/movies", want: http.StatusOK},
+		{name: "list movies invalid filters", method: "GET", path: "/v1/movies?page=0&sort=rating", want: http.StatusUnprocessableEntity},
+
+		{name: "create movie anonymous", method: "POST", path: "/v1/movies", body: `{"title":"Up","year":2009,"runtime":"96 mins","genres":["{genre_name}"]}`, want: http.StatusUnauthorized},
+		{name: "create movie inactive", method: "POST", path: "/v1/movies", user: "inactive", body: `{"title":"Up","year":2009,"runtime":"96 mins","genres":["{genre_name}"]}`, want: http.StatusForbidden},
+		{name: "create movie without permission", method: "POST", path: "/v1/movies", user: "reader", body: `{"title":"Up","year":2009,"runtime":"96 mins","genres":["{genre_name}"]}`, want: http.StatusForbidden},
+		{name: "create movie", method: "POST", path: "/v1/movies", user: "writer", body: `{"title":"Up","year":2009,"runtime":"96 mins","genres":["{genre_name}"]}`, want: http.StatusCreated},
+		{name: "create movie unknown genre", method: "POST", path: "/v1/movies", user: "writer", body: `{"title":"Up","year":2009,"runtime":"96 mins","genres":["no such genre"]}`, want: http.StatusUnprocessableEntity},
+		{name: "create movie invalid", method: "POST", path: "/v1/movies", user: "writer", body: `{"title":"","year":1500,"runtime":"96 mins","genres":[]}`, want: http.StatusUnprocessableEntity},
+		{name: "create movie badly-formed JSON", method: "POST", path: "/v1/movies", user: "writer", body: `{"title":`, want: http.StatusBadRequest},
+
+		{name: "show movie anonymous", method: "GET", path: "/v1/movies/{movie}", want: http.StatusUnauthorized},
+		{name: "show movie", method: "GET", path: "/v1/movies/{movie}", user: "reader", want: http.StatusOK},
+		{name: "show movie missing", method: "GET", path: "/v1/movies/999999", user: "reader", want: http.StatusNotFound},
+		{name: "show movie invalid id", method: "GET", path: "/v1/movies/abc", user: "reader", want: http.StatusNotFound},
+		{name: "show movie in trash", method: "GET", path: "/v1/movies/{trashed}", user: "reader", want: http.StatusNotFound},
+		{name: "show movie not modified", method: "GET", path: "/v1/movies/{movie}", user: "reader", header: map[string]string{"If-None-Match": "{movie_etag}"}, want: http.StatusNotModified},
+		{name: "show movie not modified weak", method: "GET", path: "/v1/movies/{movie}", user: "reader", header: map[string]string{"If-None-Match": "W/{movie_etag}"}, want: http.StatusNotModified},
+
+		{name: "suggest movies anonymous", method: "GET", path: "/v1/movies/suggest?prefix=moa", want: http.StatusOK},
+		{name: "suggest movies without prefix", method: "GET", path: "/v1/movies/suggest", want: http.StatusUnprocessableEntity},
+
+		{name: "export movies without permission", method: "GET", path: "/v1/movies/export?format=csv", user: "reader", want: http.StatusForbidden},
+		{name: "export movies", method: "GET", path: "/v1/movies/export?format=csv", user: "writer", want: http.StatusOK},
+		{name: "export movies invalid format", method: "GET", path: "/v1/movies/export?format=xml", user: "writer", want: http.StatusUnprocessableEntity},
+
+		{name: "list trash without permission", method: "GET", path: "/v1/movies/trash", user: "reader", want: http.StatusForbidden},
+		{name: "list trash", method: "GET", path: "/v1/movies/trash", user: "writer", want: http.StatusOK},
+
+		{name: "import movies without permission", method: "POST", path: "/v1/movies/import", user: "reader", header: map[string]string{"Content-Type": "text/csv"}, body: "title,year,runtime,genres\nUp,2009,96 mins,{genre_name}\n", want: http.StatusForbidden},
+		{name: "import movies", method: "POST", path: "/v1/movies/import", user: "writer", header: map[string]string{"Content-Type": "text/csv"}, body: "title,year,runtime,genres\nUp,2009,96 mins,{genre_name}\n", want: http.StatusCreated},
+		{name: "import movies invalid line", method: "POST", path: "/v1/movies/import", user: "writer", header: map[string]string{"Content-Type": "text/csv"}, body: "title,year,runtime,genres\nUp,2009,96 minutes,{genre_name}\n", want: http.StatusUnprocessableEntity},
+		{name: "import movies unsupported type", method: "POST", path: "/v1/movies/import", user: "writer", header: map[string]string{"Content-Type": "text/plain"}, body: "Up", want: http.StatusUnsupportedMediaType},
+		{name: "post to movie", method: "POST", path: "/v1/movies/{movie}", user: "writer", want: http.StatusMethodNotAllowed},
+
+		{name: "update movie without permission", method: "PATCH", path: "/v1/movies/{movie}", user: "reader", body: `{"title":"Moana (2016)"}`, want: http.StatusForbidden},
+		{name: "update movie", method: "PATCH", path: "/v1/movies/{movie}", user: "writer", body: `{"title":"Moana (2016)"}`, want: http.StatusOK},
+		{name: "update movie merge patch", method: "PATCH", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"Content-Type": "application/merge-patch+json"}, body: `{"year":2017}`, want: http.StatusOK},
+		{name: "update movie JSON patch", method: "PATCH", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"Content-Type": "application/json-patch+json"}, body: `[{"op":"replace","path":"/year","value":2017}]`, want: http.StatusOK},
+		{name: "update movie If-Match", method: "PATCH", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"If-Match": "{movie_etag}"}, body: `{"year":2017}`, want: http.StatusOK},
+		{name: "update movie version If-Match", method: "PATCH", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"If-Match": `"{movie}-1"`}, body: `{"year":2017}`, want: http.StatusOK},
+		{name: "update movie weak If-Match", method: "PATCH", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"If-Match": "W/{movie_etag}"}, body: `{"year":2017}`, want: http.StatusPreconditionFailed},
+		{name: "update movie stale If-Match", method: "PATCH", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"If-Match": `"7"`}, body: `{"year":2017}`, want: http.StatusPreconditionFailed},
+		{name: "update movie invalid", method: "PATCH", path: "/v1/movies/{movie}", user: "writer", body: `{"year":1500}`, want: http.StatusUnprocessableEntity},
+		{name: "update movie missing", method: "PATCH", path: "/v1/movies/999999", user: "writer", body: `{"year":2017}`, want: http.StatusNotFound},
+
+		{name: "delete movie without permission", method: "DELETE", path: "/v1/movies/{movie}", user: "reader", want: http.StatusForbidden},
+		{name: "delete movie", method: "DELETE", path: "/v1/movies/{movie}", user: "writer", want: http.StatusOK},
+		{name: "delete movie If-Match", method: "DELETE", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"If-Match": "{movie_etag}"}, want: http.StatusOK},
+		{name: "delete movie weak If-Match", method: "DELETE", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"If-Match": "W/{movie_etag}"}, want: http.StatusPreconditionFailed},
+		{name: "delete movie stale If-Match", method: "DELETE", path: "/v1/movies/{movie}", user: "writer", header: map[string]string{"If-Match": `"7"`}, want: http.StatusPreconditionFailed},
+		{name: "delete movie missing", method: "DELETE", path: "/v1/movies/999999", user: "writer", want: http.StatusNotFound},
+		{name: "restore movie", method: "POST", path: "/v1/movies/{trashed}/restore", user: "writer", want: http.StatusOK},
+		{name: "restore movie not in trash", method: "POST", path: "/v1/movies/{movie}/restore", user: "writer", want: http.StatusNotFound},
+
+		{name: "list revisions", method: "GET", path: "/v1/movies/{movie}/revisions", user: "reader", want: http.StatusOK},
+		{name: "show revision", method: "GET", path: "/v1/movies/{movie}/revisions/1", user: "reader", want: http.StatusOK},
+		{name: "show revision missing", method: "GET", path: "/v1/movies/{movie}/revisions/99", user: "reader", want: http.StatusNotFound},
+		{name: "revert movie without permission", method: "POST", path: "/v1/movies/{movie}/revisions/1/revert", user: "reader", want: http.StatusForbidden},
+		{name: "revert movie", method: "POST", path: "/v1/movies/{movie}/revisions/1/revert", user: "writer", want: http.StatusOK},
+
+		{name: "show credits", method: "GET", path: "/v1/movies/{movie}/credits", user: "reader", want: http.StatusOK},
+		{name: "update credits", method: "PUT", path: "/v1/movies/{movie}/credits", user: "writer", body: `{"credits":[{"person_id":{person},"role":"director"}]}`, want: http.StatusOK},
+		{name: "update credits without permission", method: "PUT", path: "/v1/movies/{movie}/credits", user: "reader", body: `{"credits":[]}`, want: http.StatusForbidden},
+
+		{name: "list reviews", method: "GET", path: "/v1/movies/{movie}/reviews", user: "reader", want: http.StatusOK},
+		{name: "create review anonymous", method: "POST", path: "/v1/movies/{movie}/reviews", body: `{"rating":7}`, want: http.StatusUnauthorized},
+		{name: "create review inactive", method: "POST", path: "/v1/movies/{movie}/reviews", user: "inactive", body: `{"rating":7}`, want: http.StatusForbidden},
+		{name: "create review", method: "POST", path: "/v1/movies/{movie}/reviews", user: "reader", body: `{"rating":7}`, want: http.StatusCreated},
+		{name: "create review of trashed movie", method: "POST", path: "/v1/movies/{trashed}/reviews", user: "reader", body: `{"rating":7}`, want: http.StatusNotFound},
+		{name: "update review", method: "PATCH", path: "/v1/movies/{movie}/reviews/{review}", user: "writer", body: `{"rating":9}`, want: http.StatusOK},
+		{name: "update review of another user", method: "PATCH", path: "/v1/movies/{movie}/reviews/{review}", user: "reader", body: `{"rating":1}`, want: http.StatusForbidden},
+		{name: "delete review", method: "DELETE", path: "/v1/movies/{movie}/reviews/{review}", user: "writer", want: http.StatusOK},
+
+		{name: "list people", method: "GET", path: "/v1/people", user: "reader", want: http.StatusOK},
+		{name: "create person without permission", method: "POST", path: "/v1/people", user: "reader", body: `{"name":"John Musker"}`, want: http.StatusForbidden},
+		{name: "create person", method: "POST", path: "/v1/people", user: "writer", body: `{"name":"John Musker"}`, want: http.StatusCreated},
+		{name: "show person", method: "GET", path: "/v1/people/{person}", user: "reader", want: http.StatusOK},
+		{name: "update person", method: "PATCH", path: "/v1/people/{person}", user: "writer", body: `{"name":"Ronald Clements"}`, want: http.StatusOK},
+		{name: "delete person", method: "DELETE", path: "/v1/people/{person}", user: "writer", want: http.StatusOK},
+
+		{name: "list genres anonymous", method: "GET", path: "/v1/genres", want: http.StatusUnauthorized},
+		{name: "list genres", method: "GET", path: "/v1/genres", user: "reader", want: http.StatusOK},
+		{name: "create genre without permission", method: "POST", path: "/v1/genres", user: "reader", body: `{"name":"western"}`, want: http.StatusForbidden},
+		{name: "create genre", method: "POST", path: "/v1/genres", user: "writer", body: `{"name":"western"}`, want: http.StatusCreated},
+		{name: "create genre duplicate", method: "POST", path: "/v1/genres", user: "writer", body: `{"name":"{genre_name}"}`, want: http.StatusUnprocessableEntity},
+		{name: "show genre", method: "GET", path: "/v1/genres/{genre}", user: "reader", want: http.StatusOK},
+		{name: "show genre missing", method: "GET", path: "/v1/genres/999999", user: "reader", want: http.StatusNotFound},
+		{name: "update genre", method: "PATCH", path: "/v1/genres/{unused_genre}", user: "writer", body: `{"name":"noir"}`, want: http.StatusOK},
+		{name: "delete genre", method: "DELETE", path: "/v1/genres/{unused_genre}", user: "writer", want: http.StatusOK},
+		{name: "delete genre in use", method: "DELETE", path: "/v1/genres/{genre}", user: "writer", want: http.StatusConflict},
+
+		{name: "register user", method: "POST", path: "/v1/users", body: `{"name":"Alice","email":"alice@example.com","password":"pa55word1234"}`, want: http.StatusCreated},
+		{name: "register user duplicate email", method: "POST", path: "/v1/users", body: `{"name":"Alice","email":"{writer_email}","password":"pa55word1234"}`, want: http.StatusUnprocessableEntity},
+		{name: "register user invalid", method: "POST", path: "/v1/users", body: `{"name":"","email":"alice","password":"short"}`, want: http.StatusUnprocessableEntity},
+		{name: "activate user unknown token", method: "PUT", path: "/v1/users/activated", body: `{"token":"AAAAAAAAAAAAAAAAAAAAAAAAAA"}`, want: http.StatusUnprocessableEntity},
+		{name: "activate user malformed token", method: "PUT", path: "/v1/users/activated", body: `{"token":"abc"}`, want: http.StatusUnprocessableEntity},
+
+		{name: "reset password invalid token", method: "PUT", path: "/v1/users/password", body: `{"password":"n3wpa55word","token":"AAAAAAAAAAAAAAAAAAAAAAAAAA"}`, want: http.StatusUnprocessableEntity},
+		{name: "reset password invalid", method: "PUT", path: "/v1/users/password", body: `{"password":"short","token":"abc"}`, want: http.StatusUnprocessableEntity},
+
+		{name: "list watchlist anonymous", method: "GET", path: "/v1/users/me/watchlist", want: http.StatusUnauthorized},
+		{name: "list watchlist", method: "GET", path: "/v1/users/me/watchlist", user: "writer", want: http.StatusOK},
+		{name: "show watchlist entry", method: "GET", path: "/v1/users/me/watchlist/{movie}", user: "writer", want: http.StatusOK},
+		{name: "show watchlist entry missing", method: "GET", path: "/v1/users/me/watchlist/{movie}", user: "reader", want: http.StatusNotFound},
+		{name: "put watchlist entry", method: "PUT", path: "/v1/users/me/watchlist/{movie}", user: "reader", body: `{"watched":true}`, want: http.StatusCreated},
+		{name: "put watchlist entry of trashed movie", method: "PUT", path: "/v1/users/me/watchlist/{trashed}", user: "reader", body: `{"watched":true}`, want: http.StatusNotFound},
+		{name: "replace watchlist entry", method: "PUT", path: "/v1/users/me/watchlist/{movie}", user: "writer", body: `{"favourite":true}`, want: http.StatusOK},
+		{name: "delete watchlist entry", method: "DELETE", path: "/v1/users/me/watchlist/{movie}", user: "writer", want: http.StatusOK},
+
+		{name: "create activation token", method: "POST", path: "/v1/tokens/activation", body: `{"email":"{writer_email}"}`, want: http.StatusAccepted},
+		{name: "create activation token unknown email", method: "POST", path: "/v1/tokens/activation", body: `{"email":"nobody@example.com"}`, want: http.StatusAccepted},
+		{name: "create activation token invalid", method: "POST", path: "/v1/tokens/activation", body: `{"email":""}`, want: http.StatusUnprocessableEntity},
+		{name: "list sessions anonymous", method: "GET", path: "/v1/users/me/sessions", want: http.StatusUnauthorized},
+		{name: "list sessions", method: "GET", path: "/v1/users/me/sessions", user: "session", want: http.StatusOK},
+		{name: "list sessions inactive", method: "GET", path: "/v1/users/me/sessions", user: "inactive", want: http.StatusOK},
+		{name: "delete session", method: "DELETE", path: "/v1/users/me/sessions/{session}", user: "session", want: http.StatusOK},
+		{name: "delete session of another user", method: "DELETE", path: "/v1/users/me/sessions/{session}", user: "reader", want: http.StatusNotFound},
+		{name: "delete session missing", method: "DELETE", path: "/v1/users/me/sessions/999999", user: "session", want: http.StatusNotFound},
+
+		{name: "create authentication token", method: "POST", path: "/v1/tokens/authentication", body: `{"email":"{writer_email}","password":"pa55word1234"}`, want: http.StatusCreated},
+		{name: "create authentication token wrong password", method: "POST", path: "/v1/tokens/authentication", body: `{"email":"{writer_email}","password":"wrongpa55word"}`, want: http.StatusUnauthorized},
+		{name: "create authentication token unknown email", method: "POST", path: "/v1/tokens/authentication", body: `{"email":"nobody@example.com","password":"pa55word1234"}`, want: http.StatusUnauthorized},
+		{name: "create authentication token invalid", method: "POST", path: "/v1/tokens/authentication", body: `{"email":"","password":""}`, want: http.StatusUnprocessableEntity},
+		{name: "create refresh token unknown", method: "POST", path: "/v1/tokens/refresh", body: `{"refresh_token":"AAAAAAAAAAAAAAAAAAAAAAAAAA"}`, want: http.StatusUnauthorized},
+		{name: "create refresh token invalid", method: "POST", path: "/v1/tokens/refresh", body: `{"refresh_token":""}`, want: http.StatusUnprocessableEntity},
+		{name: "delete authentication token anonymous", method: "DELETE", path: "/v1/tokens/authentication", want: http.StatusUnauthorized},
+		{name: "delete authentication token", method: "DELETE", path: "/v1/tokens/authentication", user: "session", want: http.StatusOK},
+		{name: "delete all authentication tokens anonymous", method: "DELETE", path: "/v1/tokens/authentication/all", want: http.StatusUnauthorized},
+		{name: "delete all authentication tokens", method: "DELETE", path: "/v1/tokens/authentication/all", user: "session", want: http.StatusOK},
+		{name: "create password reset token", method: "POST", path: "/v1/tokens/password-reset", body: `{"email":"{writer_email}"}`, want: http.StatusAccepted},
+		{name: "create password reset token unknown email", method: "POST", path: "/v1/tokens/password-reset", body: `{"email":"nobody@example.com"}`, want: http.StatusAccepted},
+		{name: "create password reset token invalid", method: "POST", path: "/v1/tokens/password-reset", body: `{"email":"nobody"}`, want: http.StatusUnprocessableEntity},
+	}
+
+	for _, backend := range testBackends {
+		backend := backend
+
+		t.Run(string(backend), func(t *testing.T) {
+			app := newTestApplication(t, backend)
+			users := newRouteUsers(t, app)
+			ts := newTestServer(t, app.routes())
+
+			for _, tt := range tests {
+				tt := tt
+
+				t.Run(tt.name, func(t *testing.T) {
+					f := newRouteFixture(t, app, users)
+
+					var body any
+					if tt.body != "" {
+						body = f.expand(tt.body)
+					}
+
+					token := f.tokens[tt.user]
+					if tt.user == "session" {
+						token = f.sessionToken
+					}
+
+					req := ts.newRequest(t, tt.method, f.expand(tt.path), body, token)
+					for key, value := range tt.header {
+						req.Header.Set(key, f.expand(value))
+					}
+
+					res := ts.do(t, req)
+
+					if res.status != tt.want {
+						t.Errorf("got status %d; want %d; body: %s", res.status, tt.want, res.body)
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestRegistrationFlow registers a user, activates them with the token from the
+// welcome email, logs in and reads a movie.
+func TestRegistrationFlow(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		_, writerToken := app.newTestUser(t, true, "movies:read", "movies:write")
+		genre := app.newTestGenre(t)
+
+		res := ts.request(t, "POST", "/v1/movies", map[string]any{
+			"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{genre.Name},
+		}, writerToken)
+		if res.status != http.StatusCreated {
+			t.Fatalf("creating movie: got status %d; body: %s", res.status, res.body)
+		}
+		location := res.header.Get("Location")
+
+		credentials := map[string]string{"email": "faith@example.com", "password": "pa55word1234"}
+
+		res = ts.request(t, "POST", "/v1/users", map[string]string{
+			"name": "Faith Smith", "email": credentials["email"], "password": credentials["password"],
+		}, "")
+		if res.status != http.StatusCreated {
+			t.Fatalf("registering: got status %d; body: %s", res.status, res.body)
+		}
+
+		var registered struct {
+			User struct {
+				Activated bool `json:"activated"`
+			} `json:"user"`
+		}
+		res.decode(t, &registered)
+		if registered.User.Activated {
+			t.Error("new user is already activated")
+		}
+
+		// Logging in works before activation, but the movie can't be read yet.
+		res = ts.request(t, "POST", "/v1/tokens/authentication", credentials, "")
+		if res.status != http.StatusCreated {
+			t.Fatalf("logging in: got status %d; body: %s", res.status, res.body)
+		}
+
+		var auth struct {
+			Token struct {
+				Token string `json:"token"`
+			} `json:"authentication_token"`
+		}
+		res.decode(t, &auth)
+
+		res = ts.request(t, "GET", location, nil, auth.Token.Token)
+		if res.status != http.StatusForbidden {
+			t.Fatalf("reading movie before activation: got status %d; want %d", res.status, http.StatusForbidden)
+		}
+
+		app.wg.Wait()
+		activationToken := app.smtp.lastToken(t, credentials["email"])
+
+		res = ts.request(t, "PUT", "/v1/users/activated", map[string]string{"token": activationToken}, "")
+		if res.status != http.StatusOK {
+			t.Fatalf("activating: got status %d; body: %s", res.status, res.body)
+		}
+
+		// The activation token can only be used once.
+		res = ts.request(t, "PUT", "/v1/users/activated", map[string]string{"token": activationToken}, "")
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("reusing activation token: got status %d; want %d", res.status, http.StatusUnprocessableEntity)
+		}
+
+		res = ts.request(t, "GET", location, nil, auth.Token.Token)
+		if res.status != http.StatusOK {
+			t.Fatalf("reading movie after activation: got status %d; body: %s", res.status, res.body)
+		}
+	})
+}
+
+// TestMovieLifecycle checks the ETag handling, revision history, trash and keyset
+// pagination of movies.
+func TestMovieLifecycle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		writer, token := app.newTestUser(t, true, "movies:read", "movies:write")
+		genre := app.newTestGenre(t)
+		movie := app.newTestMovie(t, writer.ID, "Moana", genre.Name)
+		path := fmt.Sprintf("/v1/movies/%d", movie.ID)
+
+		res := ts.request(t, "GET", path, nil, token)
+		etag := res.header.Get("ETag")
+		if etag == "" {
+			t.Fatal("got no ETag")
+		}
+
+		req := ts.newRequest(t, "PATCH", path, map[string]any{"year": 2017}, token)
+		req.Header.Set("If-Match", etag)
+		res = ts.do(t, req)
+		if res.status != http.StatusOK {
+			t.Fatalf("updating: got status %d; body: %s", res.status, res.body)
+		}
+
+		// The ETag of the update response matches the movie as it is read back.
+		updated := res.header.Get("ETag")
+		if got := ts.request(t, "GET", path, nil, token).header.Get("ETag"); got != updated {
+			t.Errorf("got ETag %s after update; want %s", got, updated)
+		}
+
+		// The old ETag is now stale.
+		req = ts.newRequest(t, "PATCH", path, map[string]any{"year": 2018}, token)
+		req.Header.Set("If-Match", etag)
+		res = ts.do(t, req)
+		if res.status != http.StatusPreconditionFailed {
+			t.Errorf("updating with stale ETag: got status %d; want %d", res.status, http.StatusPreconditionFailed)
+		}
+
+		res = ts.request(t, "POST", path+"/revisions/1/revert", nil, token)
+		if res.status != http.StatusOK {
+			t.Fatalf("reverting: got status %d; body: %s", res.status, res.body)
+		}
+
+		var reverted struct {
+			Movie data.Movie `json:"movie"`
+		}
+		res.decode(t, &reverted)
+		if reverted.Movie.Year != 2016 || reverted.Movie.Version != 3 {
+			t.Errorf("got year %d and version %d after revert; want 2016 and 3", reverted.Movie.Year, reverted.Movie.Version)
+		}
+
+		res = ts.request(t, "GET", path+"/revisions", nil, token)
+		var revisions struct {
+			Revisions []data.MovieRevision `json:"revisions"`
+		}
+		res.decode(t, &revisions)
+		if len(revisions.Revisions) != 3 {
+			t.Errorf("got %d revisions; want 3", len(revisions.Revisions))
+		}
+
+		// Deleting is conditional on the version, so an edit made after the movie was
+		// read, here the revert, stops a delete based on the earlier version.
+		err := app.models.Movies.Delete(context.Background(), movie.ID, movie.Version, writer.ID)
+		if !errors.Is(err, data.ErrEditConflict) {
+			t.Errorf("deleting stale version: got error %v; want %v", err, data.ErrEditConflict)
+		}
+
+		res = ts.request(t, "DELETE", path, nil, token)
+		if res.status != http.StatusOK {
+			t.Fatalf("deleting: got status %d; body: %s", res.status, res.body)
+		}
+		if res := ts.request(t, "GET", path, nil, token); res.status != http.StatusNotFound {
+			t.Errorf("reading deleted movie: got status %d; want %d", res.status, http.StatusNotFound)
+		}
+
+		res = ts.request(t, "POST", path+"/restore", nil, token)
+		if res.status != http.StatusOK {
+			t.Fatalf("restoring: got status %d; body: %s", res.status, res.body)
+		}
+		res = ts.request(t, "GET", path, nil, token)
+		if res.status != http.StatusOK {
+			t.Errorf("reading restored movie: got status %d; want %d", res.status, http.StatusOK)
+		}
+		etag = res.header.Get("ETag")
+
+		// Renaming a genre changes the movie's body without changing its version, so
+		// the ETag changes too.
+		genre.Name += " renamed"
+		err = app.models.Genres.Update(context.Background(), genre)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = ts.newRequest(t, "GET", path, nil, token)
+		req.Header.Set("If-None-Match", etag)
+		res = ts.do(t, req)
+		if res.status != http.StatusOK {
+			t.Errorf("reading after genre rename: got status %d; want %d", res.status, http.StatusOK)
+		}
+		if res.header.Get("ETag") == etag {
+			t.Errorf("got the same ETag %s after genre rename", etag)
+		}
+
+		// The movie's version hasn't changed though, so the old ETag still passes an
+		// If-Match precondition.
+		req = ts.newRequest(t, "PATCH", path, map[string]any{"runtime": "108 mins"}, token)
+		req.Header.Set("If-Match", etag)
+		res = ts.do(t, req)
+		if res.status != http.StatusOK {
+			t.Errorf("updating after genre rename: got status %d; want %d", res.status, http.StatusOK)
+		}
+
+		// Page through the movies by cursor, two at a time.
+		for _, title := range []string{"Black Panther", "Deadpool", "The Breakfast Club"} {
+			app.newTestMovie(t, writer.ID, title, genre.Name)
+		}
+
+		var titles []string
+		next := "/v1/movies?sort=title&page_size=2&cursor="
+
+		for i := 0; next != ""; i++ {
+			if i > 3 {
+				t.Fatal("too many pages")
+			}
+
+			res = ts.request(t, "GET", next, nil, "")
+			if res.status != http.StatusOK {
+				t.Fatalf("listing: got status %d; body: %s", res.status, res.body)
+			}
+
+			var page struct {
+				Movies   []data.Movie  `json:"movies"`
+				Metadata data.Metadata `json:"metadata"`
+			}
+			res.decode(t, &page)
+
+			for _, movie := range page.Movies {
+				titles = append(titles, movie.Title)
+			}
+
+			next = ""
+			if page.Metadata.NextCursor != "" {
+				next = "/v1/movies?sort=title&page_size=2&cursor=" + page.Metadata.NextCursor
+			}
+		}
+
+		want := "Black Panther,Deadpool,Moana,The Breakfast Club"
+		if got := strings.Join(titles, ","); got != want {
+			t.Errorf("got titles %s; want %s", got, want)
+		}
+
+		// A cursor only works with the sort order and filters that it was issued for.
+		var first struct {
+			Metadata data.Metadata `json:"metadata"`
+		}
+		ts.request(t, "GET", "/v1/movies?sort=title&page_size=2", nil, "").decode(t, &first)
+
+		for _, query := range []string{"sort=-title", "sort=title&title=moana", "sort=title&person=1"} {
+			res = ts.request(t, "GET", "/v1/movies?page_size=2&"+query+"&cursor="+first.Metadata.NextCursor, nil, "")
+			if res.status != http.StatusUnprocessableEntity {
+				t.Errorf("replaying cursor with %s: got status %d; want %d", query, res.status, http.StatusUnprocessableEntity)
+			}
+		}
+	})
+}
+
+// racingMovies is a MovieStore which lets another writer update a movie between the
+// handler reading it and writing it back, when armed.
+type racingMovies struct {
+	data.MovieStore
+	armed bool
+}
+
+func (m *racingMovies) Update(ctx context.Context, movie *data.Movie, userID int64) error {
+	if m.armed {
+		m.armed = false
+
+		other, err := m.Get(ctx, movie.ID)
+		if err != nil {
+			return err
+		}
+		other.Year++
+
+		err = m.MovieStore.Update(ctx, other, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return m.MovieStore.Update(ctx, movie, userID)
+}
+
+// TestMovieEditRace checks that when a movie changes after the If-Match precondition
+// was checked, an update or revert gets a 412 Precondition Failed response if the
+// client sent If-Match, and a 409 Conflict response otherwise.
+func TestMovieEditRace(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		movies := &racingMovies{MovieStore: app.models.Movies}
+		app.models.Movies = movies
+		ts := newTestServer(t, app.routes())
+
+		writer, token := app.newTestUser(t, true, "movies:read", "movies:write")
+		genre := app.newTestGenre(t)
+
+		tests := []struct {
+			name    string
+			method  string
+			path    string
+			body    any
+			ifMatch bool
+			want    int
+		}{
+			{name: "update If-Match", method: "PATCH", path: "/v1/movies/%d", body: map[string]any{"runtime": "108 mins"}, ifMatch: true, want: http.StatusPreconditionFailed},
+			{name: "update", method: "PATCH", path: "/v1/movies/%d", body: map[string]any{"runtime": "108 mins"}, want: http.StatusConflict},
+			{name: "revert If-Match", method: "POST", path: "/v1/movies/%d/revisions/1/revert", ifMatch: true, want: http.StatusPreconditionFailed},
+			{name: "revert", method: "POST", path: "/v1/movies/%d/revisions/1/revert", want: http.StatusConflict},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				// The movie gets a second revision, so that there's an earlier one to
+				// revert to.
+				movie := app.newTestMovie(t, writer.ID, "Moana", genre.Name)
+
+				err := app.models.Movies.Update(context.Background(), movie, writer.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				req := ts.newRequest(t, tt.method, fmt.Sprintf(tt.path, movie.ID), tt.body, token)
+				if tt.ifMatch {
+					req.Header.Set("If-Match", versionETag(movie.ID, movie.Version))
+				}
+
+				movies.armed = true
+				res := ts.do(t, req)
+				if res.status != tt.want {
+					t.Errorf("got status %d; want %d; body: %s", res.status, tt.want, res.body)
+				}
+			})
+		}
+	})
+}
+
+// TestPatchMovieGenres checks that a JSON Patch can append a genre to a movie without
+// the client having to send the existing genres.
+func TestPatchMovieGenres(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		writer, token := app.newTestUser(t, true, "movies:read", "movies:write")
+		first, second := app.newTestGenre(t), app.newTestGenre(t)
+		movie := app.newTestMovie(t, writer.ID, "Moana", first.Name)
+
+		operations := []map[string]any{
+			{"op": "test", "path": "/genres/0", "value": first.Name},
+			{"op": "add", "path": "/genres/-", "value": second.Name},
+		}
+
+		req := ts.newRequest(t, "PATCH", fmt.Sprintf("/v1/movies/%d", movie.ID), operations, token)
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		res := ts.do(t, req)
+		if res.status != http.StatusOK {
+			t.Fatalf("patching: got status %d; body: %s", res.status, res.body)
+		}
+
+		var patched struct {
+			Movie data.Movie `json:"movie"`
+		}
+		res.decode(t, &patched)
+
+		want := first.Name + "," + second.Name
+		if got := strings.Join(patched.Movie.Genres, ","); got != want {
+			t.Errorf("got genres %s; want %s", got, want)
+		}
+	})
+}
+
+// TestPurgeTrash checks that purging the trash permanently deletes the movie, but
+// keeps its revision history.
+func TestPurgeTrash(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		writer, token := app.newTestUser(t, true, "movies:read", "movies:write")
+		movie := app.newTestMovie(t, writer.ID, "Moana")
+		path := fmt.Sprintf("/v1/movies/%d", movie.ID)
+
+		res := ts.request(t, "DELETE", path, nil, token)
+		if res.status != http.StatusOK {
+			t.Fatalf("deleting: got status %d; body: %s", res.status, res.body)
+		}
+
+		// A negative retention purges the movie that was just deleted.
+		purged, err := app.models.Movies.PurgeTrash(context.Background(), -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purged != 1 {
+			t.Errorf("purged %d movies; want 1", purged)
+		}
+
+		if res := ts.request(t, "POST", path+"/restore", nil, token); res.status != http.StatusNotFound {
+			t.Errorf("restoring purged movie: got status %d; want %d", res.status, http.StatusNotFound)
+		}
+
+		revisions, _, err := app.models.Movies.GetRevisions(context.Background(), movie.ID, data.Filters{Page: 1, PageSize: 20, Sort: "version", SortSafeList: []string{"version"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 2 || revisions[1].Action != "delete" {
+			t.Errorf("got %d revisions after purge; want the insert and delete revisions", len(revisions))
+		}
+	})
+}
+
+// TestReviewsAndCredits checks that reviews are reflected in the movie's rating, and
+// that the person filter lists the movies that a person is credited on.
+func TestReviewsAndCredits(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		writer, token := app.newTestUser(t, true, "movies:read", "movies:write")
+		_, readerToken := app.newTestUser(t, true, "movies:read")
+		movie := app.newTestMovie(t, writer.ID, "Moana")
+		app.newTestMovie(t, writer.ID, "Up")
+		path := fmt.Sprintf("/v1/movies/%d", movie.ID)
+
+		for _, review := range []struct {
+			token  string
+			rating int
+		}{{token, 7}, {readerToken, 8}} {
+			res := ts.request(t, "POST", path+"/reviews", map[string]any{"rating": review.rating}, review.token)
+			if res.status != http.StatusCreated {
+				t.Fatalf("reviewing: got status %d; body: %s", res.status, res.body)
+			}
+		}
+
+		var shown struct {
+			Movie data.Movie `json:"movie"`
+		}
+		ts.request(t, "GET", path, nil, token).decode(t, &shown)
+
+		if shown.Movie.AverageRating != 7.5 || shown.Movie.RatingCount != 2 {
+			t.Errorf("got rating %v from %d reviews; want 7.5 from 2", shown.Movie.AverageRating, shown.Movie.RatingCount)
+		}
+
+		person := &data.Person{Name: "Ron Clements"}
+		err := app.models.People.Insert(context.Background(), person)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		credits := map[string]any{"credits": []map[string]any{{"person_id": person.ID, "role": "director"}}}
+		res := ts.request(t, "PUT", path+"/credits", credits, token)
+		if res.status != http.StatusOK {
+			t.Fatalf("crediting: got status %d; body: %s", res.status, res.body)
+		}
+
+		var listed struct {
+			Movies []data.Movie `json:"movies"`
+		}
+		ts.request(t, "GET", fmt.Sprintf("/v1/movies?person=%d", person.ID), nil, token).decode(t, &listed)
+
+		if len(listed.Movies) != 1 || listed.Movies[0].ID != movie.ID {
+			t.Errorf("got %d movies for the person; want only movie %d", len(listed.Movies), movie.ID)
+		}
+	})
+}
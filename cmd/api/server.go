@@ -25,6 +25,10 @@ func (app *application) serve() error {
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
 
+	// Start the background jobs, which run until app.done is closed on shutdown.
+	app.purgeTrash(app.done)
+	app.purgeTokens(app.done)
+
 	// Start a background routine
 	go func() {
 		// Create a quit channel which carrries os.Signal values.
@@ -57,6 +61,10 @@ func (app *application) serve() error {
 			shutdownError <- srv.Shutdown(ctx)
 		}
 
+		// Now that no more requests are being handled, tell the background jobs and
+		// middleware goroutines to stop.
+		close(app.done)
+
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
@@ -66,7 +74,9 @@ func (app *application) serve() error {
 		// Call Wait() to block untl our WaitGroup counter is zero --- essentially
 		// blocking until the background goroutines have finished. Then we return nil on
 		// the shutdownError clannel, to indicate that the shutdown completed without
-		// any issues.
+		// any issues. The flushers are waited for first, as they write out the changes
+		// buffered by the middleware.
+		app.flushers.Wait()
 		app.wg.Wait()
 		shutdownError <- nil
 
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+// maxUserAgentLength is the most bytes of the User-Agent header that are stored with a
+// session.
+const maxUserAgentLength = 512
+
+// The sessionUserAgent() helper returns the User-Agent header of the request for
+// storing with a session. Clients can send anything in the header, so invalid UTF-8,
+// which PostgreSQL won't store as text, is removed and the length is limited.
+func sessionUserAgent(r *http.Request) string {
+	userAgent := strings.ToValidUTF8(r.UserAgent(), "")
+
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+
+		// Don't leave part of a multi-byte character at the end.
+		for !utf8.ValidString(userAgent) {
+			userAgent = userAgent[:len(userAgent)-1]
+		}
+	}
+
+	return userAgent
+}
+
+// The listSessionsHandler() returns the sessions that the user is logged in with,
+// marking the one that the request was made with as current.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	hash := sha256.Sum256([]byte(app.contextGetToken(r)))
+
+	for _, session := range sessions {
+		session.Current = bytes.Equal(session.Hash, hash[:])
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteSessionHandler() logs the user out of one of their sessions, using the ID
+// from the session list. Unlike DELETE /v1/tokens/authentication, it doesn't need the
+// session's token, so it can be used to log out of a lost device.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteSession(r.Context(), app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
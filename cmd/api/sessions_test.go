@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+// TestSessions logs in from two clients, checks the session list from one of them and
+// uses it to log the other one out.
+func TestSessions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		user, _ := app.newTestUser(t, true)
+
+		login := func(userAgent string) string {
+			req := ts.newRequest(t, "POST", "/v1/tokens/authentication", map[string]string{"email": user.Email, "password": "pa55word1234"}, "")
+			req.Header.Set("User-Agent", userAgent)
+
+			res := ts.do(t, req)
+			if res.status != http.StatusCreated {
+				t.Fatalf("logging in: got status %d; body: %s", res.status, res.body)
+			}
+
+			var auth struct {
+				Token struct {
+					Token string `json:"token"`
+				} `json:"authentication_token"`
+			}
+			res.decode(t, &auth)
+
+			return auth.Token.Token
+		}
+
+		phone := login("Phone/1.0")
+		laptop := login("Laptop/2.0")
+
+		res := ts.request(t, "GET", "/v1/users/me/sessions", nil, laptop)
+		if res.status != http.StatusOK {
+			t.Fatalf("listing sessions: got status %d; body: %s", res.status, res.body)
+		}
+
+		var list struct {
+			Sessions []data.Session `json:"sessions"`
+		}
+		res.decode(t, &list)
+
+		// As well as the two new sessions, there is the token from newTestUser().
+		if len(list.Sessions) != 3 {
+			t.Fatalf("got %d sessions; want 3", len(list.Sessions))
+		}
+
+		var phoneID int64
+
+		for _, session := range list.Sessions {
+			switch session.UserAgent {
+			case "Laptop/2.0":
+				if !session.Current {
+					t.Error("laptop session isn't marked as current")
+				}
+			case "Phone/1.0":
+				phoneID = session.ID
+				if session.Current {
+					t.Error("phone session is marked as current")
+				}
+			default:
+				continue
+			}
+
+			if session.IP != "127.0.0.1" {
+				t.Errorf("got IP %q; want 127.0.0.1", session.IP)
+			}
+			if session.CreatedAt.IsZero() || session.LastUsedAt.Before(session.CreatedAt) {
+				t.Errorf("got created_at %v and last_used_at %v", session.CreatedAt, session.LastUsedAt)
+			}
+		}
+
+		// The response mustn't give away the tokens or their hashes.
+		if strings.Contains(string(res.body), phone) || strings.Contains(string(res.body), "hash") {
+			t.Errorf("session list contains secrets: %s", res.body)
+		}
+
+		res = ts.request(t, "DELETE", fmt.Sprintf("/v1/users/me/sessions/%d", phoneID), nil, laptop)
+		if res.status != http.StatusOK {
+			t.Fatalf("deleting session: got status %d; body: %s", res.status, res.body)
+		}
+
+		if res := ts.request(t, "GET", "/v1/users/me/sessions", nil, phone); res.status != http.StatusUnauthorized {
+			t.Errorf("using deleted session: got status %d; want %d", res.status, http.StatusUnauthorized)
+		}
+		if res := ts.request(t, "GET", "/v1/users/me/sessions", nil, laptop); res.status != http.StatusOK {
+			t.Errorf("using remaining session: got status %d; want %d", res.status, http.StatusOK)
+		}
+	})
+}
+
+func TestSessionUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{name: "empty", userAgent: "", want: ""},
+		{name: "plain", userAgent: "curl/8.0", want: "curl/8.0"},
+		{name: "invalid UTF-8", userAgent: "curl\xff/8.0", want: "curl/8.0"},
+		{name: "too long", userAgent: strings.Repeat("a", 600), want: strings.Repeat("a", maxUserAgentLength)},
+		{name: "split character", userAgent: strings.Repeat("a", maxUserAgentLength-1) + "é", want: strings.Repeat("a", maxUserAgentLength-1)},
+	}
+
+	for _, tt := range tests {
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("User-Agent", tt.userAgent)
+
+		if got := sessionUserAgent(r); got != tt.want {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestLastUsedWrites checks that writes for a token are held back until the interval
+// has passed, and that the held back writes are flushed later.
+func TestLastUsedWrites(t *testing.T) {
+	app := newTestApplication(t, memoryBackend)
+	l := app.newLastUsedWrites(time.Minute)
+	start := time.Now()
+
+	writes := []struct {
+		hash  string
+		after time.Duration
+		want  bool
+	}{
+		{hash: "a", after: 0, want: true},
+		{hash: "a", after: 10 * time.Second, want: false},
+		{hash: "b", after: 20 * time.Second, want: true},
+		{hash: "a", after: 59 * time.Second, want: false},
+		{hash: "a", after: 60 * time.Second, want: true},
+		{hash: "b", after: 70 * time.Second, want: false},
+		{hash: "a", after: 90 * time.Second, want: false},
+	}
+
+	for i, w := range writes {
+		if got := l.due(w.hash, start.Add(w.after)); got != w.want {
+			t.Errorf("write %d for %q after %v: got %t; want %t", i+1, w.hash, w.after, got, w.want)
+		}
+	}
+
+	// The use of b after 70 seconds can be written a minute after its last write, but
+	// the use of a after 90 seconds is held back until the final flush.
+	flushes := []struct {
+		at   time.Time
+		want map[string]time.Time
+	}{
+		{at: start.Add(100 * time.Second), want: map[string]time.Time{"b": start.Add(70 * time.Second)}},
+		{at: start.Add(110 * time.Second), want: map[string]time.Time{}},
+		{at: time.Time{}, want: map[string]time.Time{"a": start.Add(90 * time.Second)}},
+	}
+
+	for i, f := range flushes {
+		if got := l.flush(f.at); !reflect.DeepEqual(got, f.want) {
+			t.Errorf("flush %d: got %v; want %v", i+1, got, f.want)
+		}
+	}
+}
+
+// TestLastUsedFlushOnShutdown checks that a held back last used time is written when
+// the application shuts down.
+func TestLastUsedFlushOnShutdown(t *testing.T) {
+	app := newTestApplication(t, memoryBackend)
+	user, token := app.newTestUser(t, true)
+
+	done := make(chan struct{})
+	app.done = done
+	l := app.newLastUsedWrites(time.Minute)
+
+	hash := sha256.Sum256([]byte(token))
+	start := time.Now().Add(time.Hour)
+
+	l.due(string(hash[:]), start)
+	l.due(string(hash[:]), start.Add(30*time.Second))
+
+	close(done)
+	app.flushers.Wait()
+
+	sessions, err := app.models.Tokens.GetSessions(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := start.Add(30 * time.Second).Round(time.Second)
+	if len(sessions) != 1 || !sessions[0].LastUsedAt.Equal(want) {
+		t.Errorf("got sessions %+v; want one last used at %v", sessions, want)
+	}
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/data"
+	"github.com/ynrfin/greenlight/internal/data/memory"
+	"github.com/ynrfin/greenlight/internal/jsonlog"
+	"github.com/ynrfin/greenlight/internal/mailer"
+	"golang.org/x/time/rate"
+)
+
+// testBackend selects the stores that a test application uses.
+type testBackend string
+
+const (
+	memoryBackend   testBackend = "memory"
+	postgresBackend testBackend = "postgres"
+)
+
+// testBackends lists the backends that the end-to-end tests run against. Tests using
+// the postgres backend are skipped if a throwaway PostgreSQL server couldn't be started.
+var testBackends = []testBackend{memoryBackend, postgresBackend}
+
+func TestMain(m *testing.M) {
+	stop, err := startTestPostgres()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipping PostgreSQL tests: %v\n", err)
+	}
+
+	code := m.Run()
+
+	stop()
+	os.Exit(code)
+}
+
+// testLogWriter sends the application log to the test log, so that it is only shown
+// for failing tests, or when running go test -v.
+type testLogWriter struct {
+	t *testing.T
+}
+
+func (w testLogWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// testApplication is an application for tests, along with the fake SMTP server that
+// it sends emails to.
+type testApplication struct {
+	*application
+	smtp *testSMTPServer
+}
+
+// newTestApplication() returns an application using the given backend. Rate limiting
+// is disabled, but the limiter settings are filled in so that tests can enable it.
+// The application stops its background goroutines and waits for them to finish when
+// the test ends.
+func newTestApplication(t *testing.T, backend testBackend) *testApplication {
+	var cfg config
+	cfg.env = "testing"
+	cfg.limiter.rps = 2
+	cfg.limiter.burst = 4
+	cfg.limiter.suggestRps = 10
+	cfg.limiter.suggestBurst = 20
+	cfg.limiter.activationInterval = 10 * time.Minute
+	cfg.limiter.activationBurst = 3
+	cfg.trash.retention = 30 * 24 * time.Hour
+	cfg.trash.purgeInterval = time.Hour
+	cfg.auth.accessTTL = 15 * time.Minute
+	cfg.auth.refreshTTL = 30 * 24 * time.Hour
+	cfg.auth.rotatedRetention = 7 * 24 * time.Hour
+	cfg.auth.cleanupInterval = time.Hour
+
+	cursorKey := []byte("test cursor secret")
+
+	var models data.Models
+
+	switch backend {
+	case memoryBackend:
+		models = memory.NewModels()
+	case postgresBackend:
+		models = data.NewModel(newTestDB(t), data.Timeouts{
+			Query:  time.Second,
+			Import: 10 * time.Second,
+			Export: 10 * time.Second,
+			Purge:  10 * time.Second,
+		}, cursorKey)
+	default:
+		t.Fatalf("unknown test backend %q", backend)
+	}
+
+	smtp := newTestSMTPServer(t)
+	done := make(chan struct{})
+
+	app := &application{
+		config: cfg,
+		logger: jsonlog.New(testLogWriter{t}, jsonlog.LevelInfo),
+		models: models,
+		mailer: mailer.New(smtp.host, smtp.port, "", "", "Greenlight <no-reply@greenlight.test>"),
+		done:   done,
+
+		activationLimiters: newRateLimiters(rate.Every(cfg.limiter.activationInterval), cfg.limiter.activationBurst, done),
+	}
+
+	// Cleanups run in reverse order, so the goroutines are told to stop before waiting
+	// for them, as they are when the server shuts down.
+	t.Cleanup(app.wg.Wait)
+	t.Cleanup(app.flushers.Wait)
+	t.Cleanup(func() { close(done) })
+
+	return &testApplication{application: app, smtp: smtp}
+}
+
+// forEachBackend() runs fn as a subtest for each of the test backends.
+func forEachBackend(t *testing.T, fn func(t *testing.T, app *testApplication)) {
+	for _, backend := range testBackends {
+		backend := backend
+
+		t.Run(string(backend), func(t *testing.T) {
+			fn(t, newTestApplication(t, backend))
+		})
+	}
+}
+
+var testUserCount int64
+
+// newTestUser() adds a user with the given permissions straight to the store, without
+// going through registration, and returns the user along with an authentication
+// token for them.
+func (app *testApplication) newTestUser(t *testing.T, activated bool, permissions ...string) (*data.User, string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	user := &data.User{
+		Name:      "Test User",
+		Email:     fmt.Sprintf("user%d@example.com", atomic.AddInt64(&testUserCount, 1)),
+		Activated: activated,
+	}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(permissions) > 0 {
+		err = app.models.Permissions.AddForUser(ctx, user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, token.Plaintext
+}
+
+var testGenreCount int64
+
+// newTestGenre() adds a genre with a unique name to the vocabulary.
+func (app *testApplication) newTestGenre(t *testing.T) *data.Genre {
+	t.Helper()
+
+	genre := &data.Genre{Name: fmt.Sprintf("Genre %d", atomic.AddInt64(&testGenreCount, 1))}
+
+	err := app.models.Genres.Insert(context.Background(), genre)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return genre
+}
+
+// newTestMovie() adds a movie with the given title and genres straight to the store.
+// The genres must already be in the vocabulary.
+func (app *testApplication) newTestMovie(t *testing.T, userID int64, title string, genres ...string) *data.Movie {
+	t.Helper()
+
+	movie := &data.Movie{
+		Title:   title,
+		Year:    2016,
+		Runtime: 107,
+		Genres:  genres,
+	}
+
+	err := app.models.Movies.Insert(context.Background(), movie, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return movie
+}
+
+// testServer is an httptest server for the application routes.
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return &testServer{ts}
+}
+
+// testResponse holds the parts of a response that the tests check.
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// decode() unmarshals the JSON response body into dst.
+func (res testResponse) decode(t *testing.T, dst any) {
+	t.Helper()
+
+	err := json.Unmarshal(res.body, dst)
+	if err != nil {
+		t.Fatalf("decoding response body %q: %v", res.body, err)
+	}
+}
+
+// newRequest() creates a request for the test server. A string or []byte body is sent
+// as it is, and any other non-nil body is encoded as JSON. If the token isn't empty it
+// is sent as a bearer token.
+func (ts *testServer) newRequest(t *testing.T, method, urlPath string, body any, token string) *http.Request {
+	t.Helper()
+
+	var reader io.Reader
+	contentType := ""
+
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	case []byte:
+		reader = bytes.NewReader(body)
+	default:
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(js)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req
+}
+
+// do() sends the request and reads the whole response.
+func (ts *testServer) do(t *testing.T, req *http.Request) testResponse {
+	t.Helper()
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testResponse{status: res.StatusCode, header: res.Header, body: body}
+}
+
+// request() is a shortcut for sending a request created by newRequest().
+func (ts *testServer) request(t *testing.T, method, urlPath string, body any, token string) testResponse {
+	t.Helper()
+
+	return ts.do(t, ts.newRequest(t, method, urlPath, body, token))
+}
+
+// testSMTPServer is a minimal SMTP server which accepts every message sent to it, so
+// that the emails sent by the application can be checked.
+type testSMTPServer struct {
+	host string
+	port int
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func newTestSMTPServer(t *testing.T) *testSMTPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	addr := ln.Addr().(*net.TCPAddr)
+	s := &testSMTPServer{host: addr.IP.String(), port: addr.Port}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// serve() handles a single SMTP session. Only the commands needed to deliver a message
+// without authentication or TLS are understood, and everything else is accepted.
+func (s *testSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	reply("220 localhost test SMTP server")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(command, "DATA"):
+			reply("354 end data with <CR><LF>.<CR><LF>")
+
+			var message strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(strings.TrimPrefix(line, "."))
+			}
+
+			s.mu.Lock()
+			s.messages = append(s.messages, message.String())
+			s.mu.Unlock()
+
+			reply("250 OK")
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// count() returns the number of emails which have been sent to the recipient.
+func (s *testSMTPServer) count(recipient string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, message := range s.messages {
+		if strings.Contains(message, "To: "+recipient) {
+			n++
+		}
+	}
+	return n
+}
+
+var testTokenRX = regexp.MustCompile(`"token": "([A-Z2-7]{26})"`)
+
+// lastToken() returns the token from the last email which was sent to the recipient.
+// Emails are sent in the background, so call app.wg.Wait() first.
+func (s *testSMTPServer) lastToken(t *testing.T, recipient string) string {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		message := strings.ReplaceAll(s.messages[i], "=\r\n", "")
+
+		if !strings.Contains(message, "To: "+recipient) {
+			continue
+		}
+
+		if match := testTokenRX.FindStringSubmatch(message); match != nil {
+			return match[1]
+		}
+	}
+
+	t.Fatalf("no email with a token was sent to %s", recipient)
+	return ""
+}
+
+// testPostgres holds the connection to the throwaway PostgreSQL server started by
+// startTestPostgres(). Each test gets its own database, copied from a template
+// database which has had the migrations applied.
+var testPostgres struct {
+	mu    sync.Mutex
+	admin *sql.DB
+	dsn   string
+	count int
+}
+
+// startTestPostgres() starts a PostgreSQL server in a temporary directory with initdb
+// and pg_ctl, if they are available on the PATH, and creates the template database.
+// It returns a function which stops the server again.
+func startTestPostgres() (func(), error) {
+	stop := func() {}
+
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		return stop, err
+	}
+
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		return stop, err
+	}
+
+	dir, err := os.MkdirTemp("", "greenlight-postgres-")
+	if err != nil {
+		return stop, err
+	}
+
+	dataDir := filepath.Join(dir, "data")
+
+	stop = func() {
+		if testPostgres.admin != nil {
+			testPostgres.admin.Close()
+		}
+		exec.Command(pgCtl, "-D", dataDir, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+
+	output, err := exec.Command(initdb, "-D", dataDir, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput()
+	if err != nil {
+		stop()
+		return func() {}, fmt.Errorf("initdb: %v: %s", err, output)
+	}
+
+	// Find a free port by listening on a random one and closing it again.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		stop()
+		return func() {}, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -F", port, dir)
+
+	output, err = exec.Command(pgCtl, "-D", dataDir, "-l", filepath.Join(dir, "postgres.log"), "-o", options, "-w", "start").CombinedOutput()
+	if err != nil {
+		stop()
+		return func() {}, fmt.Errorf("pg_ctl start: %v: %s", err, output)
+	}
+
+	testPostgres.dsn = fmt.Sprintf("host=127.0.0.1 port=%d user=postgres sslmode=disable", port)
+
+	testPostgres.admin, err = sql.Open("postgres", testPostgres.dsn+" dbname=postgres")
+	if err != nil {
+		stop()
+		return func() {}, err
+	}
+
+	err = createTemplateDB()
+	if err != nil {
+		stop()
+		testPostgres.dsn = ""
+		return func() {}, err
+	}
+
+	return stop, nil
+}
+
+// createTemplateDB() creates the greenlight_template database and applies the up
+// migrations to it in order.
+func createTemplateDB() error {
+	_, err := testPostgres.admin.Exec(`CREATE DATABASE greenlight_template`)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("postgres", testPostgres.dsn+" dbname=greenlight_template")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "migrations", "*.up.sql"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(string(migration))
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+	}
+
+	return nil
+}
+
+// newTestDB() creates a new database from the template for a single test, and drops it
+// again when the test ends. The test is skipped if PostgreSQL isn't available.
+func newTestDB(t *testing.T) *sql.DB {
+	if testPostgres.dsn == "" {
+		t.Skip("PostgreSQL isn't available")
+	}
+
+	// PostgreSQL can't copy the template database for two new databases at once.
+	testPostgres.mu.Lock()
+	testPostgres.count++
+	name := "greenlight_test_" + strconv.Itoa(testPostgres.count)
+	_, err := testPostgres.admin.Exec(`CREATE DATABASE ` + name + ` TEMPLATE greenlight_template`)
+	testPostgres.mu.Unlock()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", testPostgres.dsn+" dbname="+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+		testPostgres.admin.Exec(`DROP DATABASE IF EXISTS ` + name)
+	})
+
+	return db
+}
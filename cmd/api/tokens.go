@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/tomasen/realip"
 	"github.com/ynrfin/greenlight/internal/data"
 	"github.com/ynrfin/greenlight/internal/validator"
 )
@@ -36,7 +40,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -44,6 +48,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return
 	}
 
 	match, err := user.Password.Matches(input.Password)
@@ -59,19 +64,344 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with 24-hour
-	// expiry time and the scope 'authentication'
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// Otherwise, if the password is correct, we start a new family of tokens for this
+	// login and issue its first access and refresh tokens.
+	family, err := data.NewTokenFamily()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	var access, refresh *data.Token
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		access, refresh, err = app.issueTokens(r, tx, user.ID, family)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+// The createRefreshTokenHandler() exchanges a refresh token for a new access token and
+// refresh token. Refresh tokens can only be used once, so if a refresh token which has
+// already been used is presented, either the client or an attacker has a stolen copy
+// of it. We can't tell which, so the whole family of tokens is revoked and the user has
+// to log in again.
+func (app *application) createRefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hash := sha256.Sum256([]byte(input.RefreshToken))
+
+	token, err := app.models.Tokens.GetByHash(r.Context(), data.ScopeRefresh, hash[:])
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if token.Rotated {
+		app.revokeTokenFamily(w, r, token)
+		return
+	}
+
+	// Mark the refresh token as used, revoke the access token issued with it and issue
+	// the new tokens together, so that the client can't end up with neither a usable
+	// old token nor a new one. If another request rotated the token first,
+	// MarkRotated() returns ErrEditConflict, which is reuse in the same way as above.
+	var access, refresh *data.Token
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Tokens.MarkRotated(r.Context(), token.Hash)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForFamily(r.Context(), data.ScopeAuthentication, token.UserID, token.Family)
+		if err != nil {
+			return err
+		}
+
+		access, refresh, err = app.issueTokens(r, tx, token.UserID, token.Family)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.revokeTokenFamily(w, r, token)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The issueTokens() helper inserts a new access token and refresh token for the user
+// in the given family. The tokens are also the user's session on this client, so we
+// record the client's IP address and User-Agent with them.
+func (app *application) issueTokens(r *http.Request, tx data.Models, userID int64, family string) (*data.Token, *data.Token, error) {
+	access, err := data.GenerateToken(userID, app.config.auth.accessTTL, data.ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := data.GenerateToken(userID, app.config.auth.refreshTTL, data.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*data.Token{access, refresh} {
+		token.Family = family
+		token.IP = realip.FromRequest(r)
+		token.UserAgent = sessionUserAgent(r)
+
+		err = tx.Tokens.Insert(r.Context(), token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+// The revokeTokenFamily() helper responds to the reuse of a refresh token by deleting
+// every token in its family, which logs out both the client and whoever else has a
+// copy of the token.
+func (app *application) revokeTokenFamily(w http.ResponseWriter, r *http.Request, token *data.Token) {
+	err := app.models.Tokens.DeleteFamily(r.Context(), token.UserID, token.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("refresh token reused, revoked token family", map[string]string{
+		"user_id": strconv.FormatInt(token.UserID, 10),
+		"ip":      realip.FromRequest(r),
+	})
+
+	app.invalidRefreshTokenResponse(w, r)
+}
+
+// The createPasswordResetTokenHandler() emails a password reset token to the user with
+// the given email address. It sends the same 202 Accepted response whether or not a
+// matching activated account exists, so that it can't be used to find out which email
+// addresses are registered.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// An unknown email address isn't an error here, it just means that there is no one
+	// to send the email to.
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only activated users can reset their password. Inactive users need to activate
+	// their account first, which proves that they own the email address anyway.
+	if user != nil && user.Activated {
+		// Replace any earlier password reset tokens, so that only the token in the
+		// latest email can be used.
+		var token *data.Token
+
+		err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+			err := tx.Tokens.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
+			if err != nil {
+				return err
+			}
+
+			token, err = tx.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+			return err
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.PrintErr(err, nil)
+			}
+		})
+	}
+
+	env := envelope{"message": "if an activated account exists for this email address, an email will be sent to it containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createActivationTokenHandler() sends a new activation email to a user who hasn't
+// activated their account yet, for when the welcome email was lost or its token has
+// expired. Like createPasswordResetTokenHandler() it responds with 202 Accepted
+// whether or not an email was sent.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Limit the emails sent to each address, whether or not there is an account for
+	// it, so that the limit doesn't give away which addresses are registered. Email
+	// addresses are compared in lowercase, because mail servers usually ignore case.
+	if app.config.limiter.enabled && !app.activationLimiters.allow(strings.ToLower(input.Email)) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && !user.Activated {
+		// Delete the user's older activation tokens, so that only the token in the
+		// latest email can be used.
+		var token *data.Token
+
+		err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+			err := tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+			if err != nil {
+				return err
+			}
+
+			token, err = tx.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+			return err
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.PrintErr(err, nil)
+			}
+		})
+	}
+
+	env := envelope{"message": "if an account which hasn't been activated exists for this email address, an email will be sent to it containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAuthenticationTokenHandler() logs the user out by revoking the
+// authentication token that the request was made with. The user's other tokens, for
+// example on other devices, keep working.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Tokens are stored by their SHA-256 hash, in the same way as in GenerateToken().
+	hash := sha256.Sum256([]byte(app.contextGetToken(r)))
+
+	err := app.models.Tokens.DeleteByHash(r.Context(), hash[:])
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAllAuthenticationTokensHandler() logs the user out everywhere, by revoking
+// all of their authentication and refresh tokens, including the one that the request
+// was made with.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+			err := tx.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
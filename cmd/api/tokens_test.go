@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+// TestLogout checks that logging out revokes only the token that the request was made
+// with, and that logging out everywhere revokes all of the user's tokens.
+func TestLogout(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		user, _ := app.newTestUser(t, false)
+		credentials := map[string]string{"email": user.Email, "password": "pa55word1234"}
+
+		login := func() string {
+			res := ts.request(t, "POST", "/v1/tokens/authentication", credentials, "")
+			if res.status != http.StatusCreated {
+				t.Fatalf("logging in: got status %d; body: %s", res.status, res.body)
+			}
+
+			var auth struct {
+				Token struct {
+					Token string `json:"token"`
+				} `json:"authentication_token"`
+			}
+			res.decode(t, &auth)
+
+			return auth.Token.Token
+		}
+
+		// The user isn't activated, so requests to routes which only need an
+		// authenticated user show whether a token still works.
+		checkToken := func(name, token string, want int) {
+			t.Helper()
+
+			res := ts.request(t, "GET", "/v1/users/me/watchlist", nil, token)
+			if res.status != want {
+				t.Errorf("using %s token: got status %d; want %d", name, res.status, want)
+			}
+		}
+
+		phone, laptop, tablet := login(), login(), login()
+
+		res := ts.request(t, "DELETE", "/v1/tokens/authentication", nil, phone)
+		if res.status != http.StatusOK {
+			t.Fatalf("logging out: got status %d; body: %s", res.status, res.body)
+		}
+
+		checkToken("revoked", phone, http.StatusUnauthorized)
+		checkToken("laptop", laptop, http.StatusForbidden)
+		checkToken("tablet", tablet, http.StatusForbidden)
+
+		res = ts.request(t, "DELETE", "/v1/tokens/authentication/all", nil, laptop)
+		if res.status != http.StatusOK {
+			t.Fatalf("logging out everywhere: got status %d; body: %s", res.status, res.body)
+		}
+
+		checkToken("laptop", laptop, http.StatusUnauthorized)
+		checkToken("tablet", tablet, http.StatusUnauthorized)
+	})
+}
+
+// TestRefreshTokens checks that a refresh token can be exchanged for new tokens only
+// once, and that presenting it again revokes every token descended from the login.
+func TestRefreshTokens(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		user, _ := app.newTestUser(t, false)
+
+		type tokens struct {
+			Access struct {
+				Token string `json:"token"`
+			} `json:"authentication_token"`
+			Refresh struct {
+				Token string `json:"token"`
+			} `json:"refresh_token"`
+		}
+
+		login := func() tokens {
+			res := ts.request(t, "POST", "/v1/tokens/authentication", map[string]string{"email": user.Email, "password": "pa55word1234"}, "")
+			if res.status != http.StatusCreated {
+				t.Fatalf("logging in: got status %d; body: %s", res.status, res.body)
+			}
+
+			var got tokens
+			res.decode(t, &got)
+			return got
+		}
+
+		refresh := func(token string, want int) tokens {
+			t.Helper()
+
+			res := ts.request(t, "POST", "/v1/tokens/refresh", map[string]string{"refresh_token": token}, "")
+			if res.status != want {
+				t.Fatalf("refreshing: got status %d; want %d; body: %s", res.status, want, res.body)
+			}
+
+			var got tokens
+			if want == http.StatusCreated {
+				res.decode(t, &got)
+			}
+			return got
+		}
+
+		checkToken := func(name, token string, want int) {
+			t.Helper()
+
+			res := ts.request(t, "GET", "/v1/users/me/watchlist", nil, token)
+			if res.status != want {
+				t.Errorf("using %s token: got status %d; want %d", name, res.status, want)
+			}
+		}
+
+		first := login()
+		if first.Refresh.Token == "" || first.Refresh.Token == first.Access.Token {
+			t.Fatalf("got refresh token %q; want a new token", first.Refresh.Token)
+		}
+
+		// Refresh tokens can't be used as access tokens.
+		checkToken("refresh", first.Refresh.Token, http.StatusUnauthorized)
+
+		// Refreshing replaces the access token as well as the refresh token.
+		second := refresh(first.Refresh.Token, http.StatusCreated)
+		checkToken("first access", first.Access.Token, http.StatusUnauthorized)
+		checkToken("second access", second.Access.Token, http.StatusForbidden)
+
+		third := refresh(second.Refresh.Token, http.StatusCreated)
+
+		// However many times it is refreshed, the login is still one session, alongside
+		// the one for the token that newTestUser() created.
+		var sessions struct {
+			Sessions []struct {
+				ID      int64 `json:"id"`
+				Current bool  `json:"current"`
+			} `json:"sessions"`
+		}
+		ts.request(t, "GET", "/v1/users/me/sessions", nil, third.Access.Token).decode(t, &sessions)
+		if len(sessions.Sessions) != 2 || !sessions.Sessions[0].Current {
+			t.Errorf("got sessions %+v; want the current session and one other", sessions.Sessions)
+		}
+
+		// Reusing a rotated refresh token revokes the whole family, including the
+		// tokens issued since.
+		refresh(first.Refresh.Token, http.StatusUnauthorized)
+		checkToken("third access", third.Access.Token, http.StatusUnauthorized)
+		refresh(third.Refresh.Token, http.StatusUnauthorized)
+
+		// Logging out also revokes the refresh token from the same login, but not those
+		// from other logins.
+		phone, laptop := login(), login()
+
+		res := ts.request(t, "DELETE", "/v1/tokens/authentication", nil, phone.Access.Token)
+		if res.status != http.StatusOK {
+			t.Fatalf("logging out: got status %d; body: %s", res.status, res.body)
+		}
+
+		refresh(phone.Refresh.Token, http.StatusUnauthorized)
+		laptop = refresh(laptop.Refresh.Token, http.StatusCreated)
+
+		// So does deleting the session.
+		tablet := login()
+
+		ts.request(t, "GET", "/v1/users/me/sessions", nil, tablet.Access.Token).decode(t, &sessions)
+
+		for _, session := range sessions.Sessions {
+			if session.Current {
+				res = ts.request(t, "DELETE", fmt.Sprintf("/v1/users/me/sessions/%d", session.ID), nil, laptop.Access.Token)
+				if res.status != http.StatusOK {
+					t.Fatalf("deleting session: got status %d; body: %s", res.status, res.body)
+				}
+			}
+		}
+
+		refresh(tablet.Refresh.Token, http.StatusUnauthorized)
+		checkToken("laptop access", laptop.Access.Token, http.StatusForbidden)
+
+		res = ts.request(t, "DELETE", "/v1/tokens/authentication/all", nil, laptop.Access.Token)
+		if res.status != http.StatusOK {
+			t.Fatalf("logging out everywhere: got status %d; body: %s", res.status, res.body)
+		}
+
+		refresh(laptop.Refresh.Token, http.StatusUnauthorized)
+	})
+}
+
+// TestDeleteExpiredTokens checks that the cleanup job's query deletes expired tokens
+// and refresh tokens which were used longer ago than the retention period, but keeps
+// recently used refresh tokens to detect their reuse.
+func TestDeleteExpiredTokens(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ctx := context.Background()
+		user, _ := app.newTestUser(t, false)
+
+		_, err := app.models.Tokens.New(ctx, user.ID, -time.Minute, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rotated, err := app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeRefresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = app.models.Tokens.MarkRotated(ctx, rotated.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		current, err := app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeRefresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exists := func(token *data.Token) bool {
+			_, err := app.models.Tokens.GetByHash(ctx, token.Scope, token.Hash)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				t.Fatal(err)
+			}
+			return err == nil
+		}
+
+		// The rotated token was used just now, so it is kept for the retention period.
+		deleted, err := app.models.Tokens.DeleteExpired(ctx, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 1 {
+			t.Errorf("deleted %d tokens; want only the expired token", deleted)
+		}
+		if !exists(rotated) || !exists(current) {
+			t.Error("the rotated and current refresh tokens were deleted")
+		}
+
+		// A negative retention deletes the rotated token too.
+		deleted, err = app.models.Tokens.DeleteExpired(ctx, -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 1 || exists(rotated) {
+			t.Errorf("deleted %d tokens; want only the rotated token", deleted)
+		}
+		if !exists(current) {
+			t.Error("the current refresh token was deleted")
+		}
+	})
+}
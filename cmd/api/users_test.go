@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestPasswordReset requests a password reset token, uses it to set a new password and
+// checks that the old password and authentication tokens no longer work.
+func TestPasswordReset(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		ts := newTestServer(t, app.routes())
+
+		user, authToken := app.newTestUser(t, true, "movies:read")
+		inactive, _ := app.newTestUser(t, false)
+
+		res := ts.request(t, "POST", "/v1/tokens/password-reset", map[string]string{"email": user.Email}, "")
+		if res.status != http.StatusAccepted {
+			t.Fatalf("requesting reset: got status %d; body: %s", res.status, res.body)
+		}
+
+		// Inactive users get the same response, but no email.
+		res = ts.request(t, "POST", "/v1/tokens/password-reset", map[string]string{"email": inactive.Email}, "")
+		if res.status != http.StatusAccepted {
+			t.Fatalf("requesting reset for inactive user: got status %d; body: %s", res.status, res.body)
+		}
+
+		app.wg.Wait()
+		resetToken := app.smtp.lastToken(t, user.Email)
+
+		if app.smtp.count(inactive.Email) != 0 {
+			t.Error("password reset email was sent to an inactive user")
+		}
+
+		reset := map[string]string{"password": "n3wpa55word", "token": resetToken}
+
+		res = ts.request(t, "PUT", "/v1/users/password", reset, "")
+		if res.status != http.StatusOK {
+			t.Fatalf("resetting password: got status %d; body: %s", res.status, res.body)
+		}
+
+		// The reset token can only be used once.
+		res = ts.request(t, "PUT", "/v1/users/password", reset, "")
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("reusing reset token: got status %d; want %d", res.status, http.StatusUnprocessableEntity)
+		}
+
+		res = ts.request(t, "GET", "/v1/genres", nil, authToken)
+		if res.status != http.StatusUnauthorized {
+			t.Errorf("using old authentication token: got status %d; want %d", res.status, http.StatusUnauthorized)
+		}
+
+		logins := []struct {
+			password string
+			want     int
+		}{
+			{password: "pa55word1234", want: http.StatusUnauthorized},
+			{password: "n3wpa55word", want: http.StatusCreated},
+		}
+
+		for _, login := range logins {
+			res = ts.request(t, "POST", "/v1/tokens/authentication", map[string]string{"email": user.Email, "password": login.password}, "")
+			if res.status != login.want {
+				t.Errorf("logging in with %q: got status %d; want %d", login.password, res.status, login.want)
+			}
+		}
+	})
+}
+
+// TestResendActivation registers a user, asks for a new activation email and checks
+// that only the new token can be used, and that the emails are limited per address.
+func TestResendActivation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, app *testApplication) {
+		// Enable rate limiting, with a global limit high enough not to get in the way.
+		app.config.limiter.enabled = true
+		app.config.limiter.rps = 100
+		app.config.limiter.burst = 100
+
+		ts := newTestServer(t, app.routes())
+
+		email := "grace@example.com"
+
+		res := ts.request(t, "POST", "/v1/users", map[string]string{"name": "Grace", "email": email, "password": "pa55word1234"}, "")
+		if res.status != http.StatusCreated {
+			t.Fatalf("registering: got status %d; body: %s", res.status, res.body)
+		}
+
+		app.wg.Wait()
+		oldToken := app.smtp.lastToken(t, email)
+
+		res = ts.request(t, "POST", "/v1/tokens/activation", map[string]string{"email": email}, "")
+		if res.status != http.StatusAccepted {
+			t.Fatalf("resending activation: got status %d; body: %s", res.status, res.body)
+		}
+
+		app.wg.Wait()
+		newToken := app.smtp.lastToken(t, email)
+
+		if newToken == oldToken {
+			t.Fatal("resent activation email has the old token")
+		}
+
+		res = ts.request(t, "PUT", "/v1/users/activated", map[string]string{"token": oldToken}, "")
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("activating with old token: got status %d; want %d", res.status, http.StatusUnprocessableEntity)
+		}
+
+		res = ts.request(t, "PUT", "/v1/users/activated", map[string]string{"token": newToken}, "")
+		if res.status != http.StatusOK {
+			t.Fatalf("activating with new token: got status %d; body: %s", res.status, res.body)
+		}
+
+		// Activated users aren't sent another email.
+		sent := app.smtp.count(email)
+
+		res = ts.request(t, "POST", "/v1/tokens/activation", map[string]string{"email": email}, "")
+		if res.status != http.StatusAccepted {
+			t.Fatalf("resending activation after activating: got status %d; body: %s", res.status, res.body)
+		}
+
+		app.wg.Wait()
+		if app.smtp.count(email) != sent {
+			t.Error("activation email was sent to an activated user")
+		}
+
+		// The limit allows a burst of three emails per address, ignoring case, and
+		// doesn't depend on whether there is an account for the address. Two of the
+		// requests for the user's address have been used already.
+		requests := []struct {
+			address string
+			want    int
+		}{
+			{address: email, want: http.StatusAccepted},
+			{address: strings.ToUpper(email), want: http.StatusTooManyRequests},
+			{address: "nobody@example.com", want: http.StatusAccepted},
+			{address: "nobody@example.com", want: http.StatusAccepted},
+			{address: "nobody@example.com", want: http.StatusAccepted},
+			{address: "NOBODY@example.com", want: http.StatusTooManyRequests},
+		}
+
+		for i, req := range requests {
+			res = ts.request(t, "POST", "/v1/tokens/activation", map[string]string{"email": req.address}, "")
+			if res.status != req.want {
+				t.Errorf("request %d for %s: got status %d; want %d", i+1, req.address, res.status, req.want)
+			}
+		}
+	})
+}
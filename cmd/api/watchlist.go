@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ynrfin/greenlight/internal/data"
+	"github.com/ynrfin/greenlight/internal/validator"
+)
+
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Watched *bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// The optional watched query string value filters the list down to watched or
+	// unwatched movies.
+	switch app.readString(qs, "watched", "") {
+	case "":
+	case "true":
+		watched := true
+		input.Watched = &watched
+	case "false":
+		watched := false
+		input.Watched = &watched
+	default:
+		v.AddError("watched", "must be true or false")
+	}
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-added_at")
+	input.Filters.SortSafeList = []string{"added_at", "updated_at", "title", "-added_at", "-updated_at", "-title"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	entries, metadata, err := app.models.Watchlist.GetAllForUser(r.Context(), user.ID, input.Watched, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIdParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	entry, err := app.models.Watchlist.Get(r.Context(), user.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The putWatchlistEntryHandler() adds a movie to the user's watchlist, or replaces the
+// watched and favourite state if it's already there. It responds with 201 Created for a
+// new entry and 200 OK otherwise.
+func (app *application) putWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIdParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Watched   bool `json:"watched"`
+		Favourite bool `json:"favourite"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	entry := &data.WatchlistEntry{
+		MovieID:   movieID,
+		Watched:   input.Watched,
+		Favourite: input.Favourite,
+	}
+
+	created, err := app.models.Watchlist.Put(r.Context(), user.ID, entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIdParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Watchlist.Delete(r.Context(), user.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded, has been
+// tampered with, or was issued for a different sort order or different filters.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// The cursor struct holds the position of a keyset page boundary: the sort value and
+// id of the last (or first, when paging backwards) record that the client has seen.
+// The sort order and a hash of the filters are included so that a cursor can't be
+// replayed against a different ORDER BY clause, or against a different set of movies,
+// where the position it holds would make no sense.
+type cursor struct {
+	Sort     string `json:"s"`
+	Filters  string `json:"f"`
+	Value    string `json:"v"`
+	ID       int64  `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// HashMovieFilters() returns a short hash of the title, genres, search and person
+// filters of a movie list, for binding cursors to the filters they were issued for.
+// The filters are normalized first in the same way as the database compares them, so
+// the title and genres are case-insensitive and the order of the genres doesn't
+// matter. It is exported for the in-memory store's cursors.
+func HashMovieFilters(title string, genres []string, search string, personID int64) string {
+	normalized := make([]string, len(genres))
+	for i, genre := range genres {
+		normalized[i] = strings.ToLower(genre)
+	}
+	sort.Strings(normalized)
+
+	js, _ := json.Marshal([]any{
+		strings.ToLower(strings.TrimSpace(title)),
+		normalized,
+		strings.TrimSpace(search),
+		personID,
+	})
+
+	hash := sha256.Sum256(js)
+	return base64.RawURLEncoding.EncodeToString(hash[:12])
+}
+
+// encode() serializes the cursor to JSON and signs it with HMAC-SHA256, returning an
+// opaque token in the format "<payload>.<signature>" where both parts are base64url
+// encoded.
+func (c cursor) encode(key []byte) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// decodeCursor() verifies the signature of a cursor token and returns the decoded
+// cursor. Any problem with the token is reported as ErrInvalidCursor, so that we don't
+// leak details about why the token was rejected.
+func decodeCursor(key []byte, token string) (*cursor, error) {
+	payloadPart, signaturePart, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+
+	enc := base64.RawURLEncoding
+
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	signature, err := enc.DecodeString(signaturePart)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// Use hmac.Equal() to compare the signatures in constant time.
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// The movieSortValue() function returns the value of the given sort column for a movie
+// as a string, ready to be embedded in a cursor. PostgreSQL infers the type of the
+// placeholder parameter from the column it is compared against, so a string
+// representation works for every column in the sort safelist.
+func movieSortValue(movie *Movie, column string) string {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "rating":
+		return strconv.FormatFloat(movie.AverageRating, 'f', -1, 64)
+	case "relevance":
+		// Format the rank with the minimum number of digits needed to round-trip
+		// the float32 exactly, so that the keyset comparison in the database is
+		// made against the same value.
+		return strconv.FormatFloat(float64(movie.Relevance), 'g', -1, 32)
+	default:
+		return strconv.FormatInt(movie.ID, 10)
+	}
+}
@@ -0,0 +1,34 @@
+package data
+
+import "testing"
+
+// TestHashMovieFilters checks that filters which select the same movies hash the same,
+// and that changing any filter changes the hash.
+func TestHashMovieFilters(t *testing.T) {
+	hash := HashMovieFilters("Moana", []string{"animation", "Adventure"}, "ocean", 0)
+
+	if got := HashMovieFilters(" moana ", []string{"adventure", "ANIMATION"}, "ocean", 0); got != hash {
+		t.Errorf("got hash %s for normalized filters; want %s", got, hash)
+	}
+
+	tests := []struct {
+		name     string
+		title    string
+		genres   []string
+		search   string
+		personID int64
+	}{
+		{name: "title", title: "Up", genres: []string{"animation", "adventure"}, search: "ocean"},
+		{name: "genres", title: "Moana", genres: []string{"animation"}, search: "ocean"},
+		{name: "search", title: "Moana", genres: []string{"animation", "adventure"}, search: "island"},
+		{name: "person", title: "Moana", genres: []string{"animation", "adventure"}, search: "ocean", personID: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HashMovieFilters(tt.title, tt.genres, tt.search, tt.personID); got == hash {
+				t.Errorf("got the same hash %s after changing the %s filter", got, tt.name)
+			}
+		})
+	}
+}
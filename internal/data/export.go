@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// exportBatchSize is the number of rows fetched from the export cursor at a time.
+const exportBatchSize = 500
+
+// Export() calls fn for every movie matching the same title, genres, full-text search
+// and person filters as GetAll(), in id order. The movies are read through a
+// server-side cursor in batches, so memory use stays the same however many movies
+// there are. If fn returns an error the export stops and the error is returned.
+func (m MovieModel) Export(ctx context.Context, title string, genres []string, search string, personID int64, fn func(movie *Movie) error) error {
+	// A full export can take a while, particularly for slow clients, so it has its own
+	// timeout rather than the one for single queries.
+	ctx, cancel := withQueryTimeout(ctx, m.ExportTimeout)
+	defer cancel()
+
+	// Cursors only exist within a transaction. Making it read-only means the whole
+	// export is read from a consistent snapshot.
+	tx, err := beginTx(ctx, m.DB, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        DECLARE movie_export NO SCROLL CURSOR FOR
+        SELECT ` + movieListColumns + `
+        FROM movies
+        WHERE ` + movieListFilters + `
+        ORDER BY id ASC`
+
+	_, err = tx.ExecContext(ctx, query, title, pq.Array(genres), search, personID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		n, err := exportBatch(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+
+		if n < exportBatchSize {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+// exportBatch() fetches the next batch of rows from the export cursor and calls fn for
+// each of them, returning the number of rows fetched.
+func exportBatch(ctx context.Context, tx DBTX, fn func(movie *Movie) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, "FETCH FORWARD "+strconv.Itoa(exportBatchSize)+" FROM movie_export")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Relevance,
+			&movie.Headline,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return 0, err
+		}
+
+		n++
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
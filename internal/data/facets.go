@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/ynrfin/greenlight/internal/validator"
+)
+
+// FacetSafeList holds the names of the facets that can be computed for a movie list.
+var FacetSafeList = []string{"genres", "year"}
+
+// FacetBucket holds the number of movies which share a single facet value.
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets maps a facet name to its buckets, ordered by descending count.
+type Facets map[string][]FacetBucket
+
+// movieFacetExpressions maps each facet name to the FROM clause and SQL expression that
+// produces the bucket value. The genres facet joins through movies_genres so that a
+// movie is counted once for each of its genres, and the year facet groups movies by
+// decade.
+var movieFacetExpressions = map[string]struct {
+	from  string
+	value string
+}{
+	"genres": {
+		from:  "movies INNER JOIN movies_genres ON movies_genres.movie_id = movies.id INNER JOIN genres ON genres.id = movies_genres.genre_id",
+		value: "genres.name",
+	},
+	"year": {from: "movies", value: "((year / 10) * 10)::text || 's'"},
+}
+
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.PermittedValue(facet, FacetSafeList...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+// GetFacets() counts the movies matching the same title, genres, full-text search and
+// person filters as GetAll(), grouped into buckets for each of the requested facets.
+func (m MovieModel) GetFacets(ctx context.Context, title string, genres []string, search string, personID int64, facets []string) (Facets, error) {
+	result := Facets{}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	args := []any{title, pq.Array(genres), search, personID}
+
+	for _, facet := range facets {
+		expression, ok := movieFacetExpressions[facet]
+		if !ok {
+			return nil, fmt.Errorf("unknown facet: %s", facet)
+		}
+
+		query := fmt.Sprintf(`
+            SELECT %[1]s AS value, count(*)
+            FROM %[2]s
+            WHERE %[3]s
+            GROUP BY value
+            ORDER BY count(*) DESC, value ASC`, expression.value, expression.from, movieListFilters)
+
+		rows, err := m.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		buckets := []FacetBucket{}
+
+		for rows.Next() {
+			var bucket FacetBucket
+
+			err := rows.Scan(&bucket.Value, &bucket.Count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			buckets = append(buckets, bucket)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		result[facet] = buckets
+	}
+
+	return result, nil
+}
@@ -1,12 +1,19 @@
 package data
 
-import "github.com/ynrfin/greenlight/internal/validator"
+import (
+	"strings"
+
+	"github.com/ynrfin/greenlight/internal/validator"
+)
 
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	// Cursor holds an opaque keyset pagination token from a previous response. When it
+	// is set the Page field is ignored and records are fetched relative to the cursor.
+	Cursor string
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -17,4 +24,70 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// check that the sort parameter is in the save list
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "Invalid sort value")
+
+	v.Check(len(f.Cursor) <= 1024, "cursor", "must not be more than 1024 bytes long")
+}
+
+// Check that the client-provided Sort field matches one of the entries in our safelist
+// and if it does, extract the column name from the Sort field by stripping the leading
+// hyphen character (if one exists). The sort value ends up interpolated into the SQL
+// query, so we panic rather than risk an SQL injection if it isn't in the safelist.
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafeList {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// Return the sort direction ("ASC" or "DESC") depending on the prefix character of the
+// Sort field.
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// The limit() method returns the number of records to fetch for a single page.
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+// The offset() method returns how many records to skip before the current page. There
+// is a theoretical risk of an integer overflow here, but it is mitigated by the maximum
+// values enforced in ValidateFilters().
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Define a new Metadata struct for holding the pagination metadata.
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+}
+
+// The calculateMetadata() function calculates the appropriate pagination metadata
+// values given the total number of records, current page, and page size values. Note
+// that the last page value is calculated by dividing and rounding up, so that a partial
+// final page is still counted.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		// Note that we return an empty Metadata struct if there are no records.
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (totalRecords + pageSize - 1) / pageSize,
+		TotalRecords: totalRecords,
+	}
 }
@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ynrfin/greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+	ErrUnknownGenre   = errors.New("unknown genre")
+)
+
+// Genre is an entry in the managed vocabulary of genres that movies can be tagged
+// with.
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(strings.TrimSpace(genre.Name) != "", "name", "must be provided")
+	v.Check(genre.Name == strings.TrimSpace(genre.Name), "name", "must not have leading or trailing spaces")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// movieGenresColumn is a correlated subquery which returns the genre names for a movie
+// as a text array, in the order that they were given when the movie was saved. It
+// expects the movies table to be in scope.
+const movieGenresColumn = `ARRAY(
+            SELECT genres.name
+            FROM movies_genres
+            INNER JOIN genres ON genres.id = movies_genres.genre_id
+            WHERE movies_genres.movie_id = movies.id
+            ORDER BY movies_genres.position)`
+
+// setMovieGenres() replaces the genres linked to a movie. Genre names are matched
+// case-insensitively against the vocabulary, and the canonical names are returned in
+// the order given. If any of the names aren't in the vocabulary ErrUnknownGenre is
+// returned. It must be called within a transaction so that the movie is never left
+// without genres.
+func setMovieGenres(ctx context.Context, tx DBTX, movieID int64, names []string) ([]string, error) {
+	_, err := tx.ExecContext(ctx, `DELETE FROM movies_genres WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        WITH inserted AS (
+            INSERT INTO movies_genres (movie_id, genre_id, position)
+            SELECT $1, genres.id, u.position
+            FROM unnest($2::text[]) WITH ORDINALITY AS u(name, position)
+            INNER JOIN genres ON lower(genres.name) = lower(u.name)
+            RETURNING genre_id, position
+        )
+        SELECT genres.name
+        FROM inserted
+        INNER JOIN genres ON genres.id = inserted.genre_id
+        ORDER BY inserted.position`
+
+	rows, err := tx.QueryContext(ctx, query, movieID, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	canonical := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		canonical = append(canonical, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(canonical) != len(names) {
+		return nil, ErrUnknownGenre
+	}
+
+	return canonical, nil
+}
+
+type GenreModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// GetAll() returns every genre in the vocabulary, ordered by name.
+func (m GenreModel) GetAll(ctx context.Context) ([]*Genre, error) {
+	query := `
+        SELECT id, created_at, name, version
+        FROM genres
+        ORDER BY lower(name), id`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.ID, &genre.CreatedAt, &genre.Name, &genre.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// GetNames() returns the names of every genre in the vocabulary, for validating the
+// genres of a movie with ValidateMovie().
+func (m GenreModel) GetNames(ctx context.Context) ([]string, error) {
+	genres, err := m.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(genres))
+	for i, genre := range genres {
+		names[i] = genre.Name
+	}
+
+	return names, nil
+}
+
+func (m GenreModel) Get(ctx context.Context, id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, created_at, name, version
+        FROM genres
+        WHERE id = $1`
+
+	var genre Genre
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&genre.ID, &genre.CreatedAt, &genre.Name, &genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+func (m GenreModel) Insert(ctx context.Context, genre *Genre) error {
+	query := `
+        INSERT INTO genres (name)
+        VALUES ($1)
+        RETURNING id, created_at, version`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, genre.Name).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_name_lower_idx"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Update() renames a genre. Because movies reference genres by id, the new name is
+// picked up by every movie tagged with the genre straight away.
+func (m GenreModel) Update(ctx context.Context, genre *Genre) error {
+	query := `
+        UPDATE genres
+        SET name = $1, version = version + 1
+        WHERE id = $2 AND version = $3
+        RETURNING version`
+
+	args := []any{genre.Name, genre.ID, genre.Version}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_name_lower_idx"`:
+			return ErrDuplicateGenre
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete() removes a genre from the vocabulary. Genres which are still linked to a
+// movie can't be deleted, and ErrGenreInUse is returned instead.
+func (m GenreModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM genres
+        WHERE id = $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: update or delete on table "genres" violates foreign key constraint "movies_genres_genre_id_fkey" on table "movies_genres"`:
+			return ErrGenreInUse
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
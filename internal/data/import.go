@@ -0,0 +1,166 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Import() inserts a batch of validated movies in a single transaction, using the
+// PostgreSQL COPY protocol rather than one INSERT per movie. Either every movie is
+// imported or, if any of them has a genre which isn't in the vocabulary, none are and
+// ErrUnknownGenre is returned. On success the ID, version and canonical genre names of
+// each movie are set.
+func (m MovieModel) Import(ctx context.Context, movies []*Movie, userID int64) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	// Importing a large batch can take a while, so it has its own timeout rather than
+	// the one for single queries.
+	ctx, cancel := withQueryTimeout(ctx, m.ImportTimeout)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// COPY can't return the generated IDs, so reserve them from the sequence first.
+	// They are needed to link the movies to their genres.
+	rows, err := tx.QueryContext(ctx, `
+        SELECT nextval(pg_get_serial_sequence('movies', 'id'))
+        FROM generate_series(1, $1)`, len(movies))
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0, len(movies))
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Load the genre vocabulary, keyed by lowercase name, so that the genres can be
+	// matched case-insensitively in the same way as setMovieGenres().
+	type genre struct {
+		id   int64
+		name string
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT id, name FROM genres`)
+	if err != nil {
+		return err
+	}
+
+	vocabulary := map[string]genre{}
+
+	for rows.Next() {
+		var g genre
+
+		err := rows.Scan(&g.id, &g.name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		vocabulary[strings.ToLower(g.name)] = g
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	err = copyRows(ctx, tx, pq.CopyIn("movies", "id", "title", "year", "runtime"), func(stmt *sql.Stmt) error {
+		for i, movie := range movies {
+			_, err := stmt.ExecContext(ctx, ids[i], movie.Title, movie.Year, movie.Runtime)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	canonical := make([][]string, len(movies))
+
+	err = copyRows(ctx, tx, pq.CopyIn("movies_genres", "movie_id", "genre_id", "position"), func(stmt *sql.Stmt) error {
+		for i, movie := range movies {
+			canonical[i] = make([]string, len(movie.Genres))
+
+			for position, name := range movie.Genres {
+				g, ok := vocabulary[strings.ToLower(name)]
+				if !ok {
+					return ErrUnknownGenre
+				}
+
+				_, err := stmt.ExecContext(ctx, ids[i], g.id, position+1)
+				if err != nil {
+					return err
+				}
+
+				canonical[i][position] = g.name
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = recordMovieRevision(ctx, tx, userID, "insert", ids...)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	for i, movie := range movies {
+		movie.ID = ids[i]
+		movie.Version = 1
+		movie.Genres = canonical[i]
+	}
+
+	return nil
+}
+
+// copyRows() prepares a COPY statement in the transaction and calls fn to send the rows
+// with stmt.ExecContext(). The buffered rows are then flushed to the server by calling
+// ExecContext() with no arguments, as required by lib/pq.
+func copyRows(ctx context.Context, tx DBTX, statement string, fn func(stmt *sql.Stmt) error) error {
+	stmt, err := tx.PrepareContext(ctx, statement)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = fn(stmt)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
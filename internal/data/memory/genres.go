@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+type genreStore struct {
+	s *store
+}
+
+func (m genreStore) GetAll(ctx context.Context) ([]*data.Genre, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	genres := []*data.Genre{}
+	for _, genre := range m.s.genres {
+		g := *genre
+		genres = append(genres, &g)
+	}
+
+	sort.Slice(genres, func(i, j int) bool {
+		a, b := strings.ToLower(genres[i].Name), strings.ToLower(genres[j].Name)
+		if a != b {
+			return a < b
+		}
+		return genres[i].ID < genres[j].ID
+	})
+
+	return genres, nil
+}
+
+func (m genreStore) GetNames(ctx context.Context) ([]string, error) {
+	genres, err := m.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(genres))
+	for i, genre := range genres {
+		names[i] = genre.Name
+	}
+
+	return names, nil
+}
+
+func (m genreStore) Get(ctx context.Context, id int64) (*data.Genre, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	genre, ok := m.s.genres[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	g := *genre
+	return &g, nil
+}
+
+func (m genreStore) Insert(ctx context.Context, genre *data.Genre) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if m.s.genreNamed(genre.Name, 0) {
+		return data.ErrDuplicateGenre
+	}
+
+	m.s.lastGenreID++
+	genre.ID = m.s.lastGenreID
+	genre.CreatedAt = now()
+	genre.Version = 1
+
+	g := *genre
+	m.s.genres[g.ID] = &g
+	return nil
+}
+
+func (m genreStore) Update(ctx context.Context, genre *data.Genre) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.genres[genre.ID]
+	if !ok || stored.Version != genre.Version {
+		return data.ErrEditConflict
+	}
+
+	if m.s.genreNamed(genre.Name, genre.ID) {
+		return data.ErrDuplicateGenre
+	}
+
+	stored.Name = genre.Name
+	stored.Version++
+
+	genre.Version = stored.Version
+	return nil
+}
+
+// Delete() refuses to delete a genre which is linked to any movie, including movies in
+// the trash, like the ON DELETE RESTRICT foreign key in the database.
+func (m genreStore) Delete(ctx context.Context, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.genres[id]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	for _, movie := range m.s.movies {
+		for _, genreID := range movie.genreIDs {
+			if genreID == id {
+				return data.ErrGenreInUse
+			}
+		}
+	}
+
+	delete(m.s.genres, id)
+	return nil
+}
+
+// genreNamed() reports whether a genre other than the one with the given ID has the
+// name, compared case-insensitively. The caller must hold the lock.
+func (s *store) genreNamed(name string, exceptID int64) bool {
+	for _, genre := range s.genres {
+		if genre.ID != exceptID && strings.EqualFold(genre.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// genreIDs() matches genre names case-insensitively against the vocabulary and returns
+// the IDs of the genres in the same order, or ErrUnknownGenre if any of them aren't in
+// the vocabulary. The caller must hold the lock.
+func (s *store) genreIDs(names []string) ([]int64, error) {
+	ids := make([]int64, len(names))
+
+	for i, name := range names {
+		found := false
+
+		for _, genre := range s.genres {
+			if strings.EqualFold(genre.Name, name) {
+				ids[i] = genre.ID
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, data.ErrUnknownGenre
+		}
+	}
+
+	return ids, nil
+}
+
+// genreNames() returns the current names of the genres with the given IDs. The caller
+// must hold the lock.
+func (s *store) genreNames(ids []int64) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = s.genres[id].Name
+	}
+	return names
+}
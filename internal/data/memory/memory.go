@@ -0,0 +1,136 @@
+// Package memory implements the stores from the data package in memory, so that the handlers in cmd/api can be tested without a
+// PostgreSQL database. The stores follow the same rules as the PostgreSQL models: a
+// stale version is reported with data.ErrEditConflict, an email address which is
+// already in use with data.ErrDuplicateEmail, expired tokens aren't matched, and so on.
+//
+// Some behaviour is only approximated:
+//
+//   - Title and full-text searches match whole words case-insensitively, without
+//     stemming or the websearch query syntax, and suggestions only match titles with a
+//     word starting with the prefix rather than using trigram similarity.
+//   - Changes aren't rolled back when the function passed to Models.WithTx() returns an
+//     error, as there is no transaction.
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+// PermissionCodes holds the permission codes which can be granted to a user, matching
+// those added by the migrations. Like PermissionModel.AddForUser(), AddForUser()
+// ignores any other codes.
+var PermissionCodes = []string{"movies:read", "movies:write", "genres:write", "movies:export"}
+
+// store holds the data for all of the stores returned by NewModels(), so that they can
+// refer to each other in the same way as the database tables do. For example, movies
+// look up their genres, and users are found by their tokens. Every method takes the
+// lock for its whole duration, which stands in for a transaction.
+type store struct {
+	mu sync.Mutex
+
+	genres      map[int64]*data.Genre
+	movies      map[int64]*movieRecord
+	revisions   map[int64][]*data.MovieRevision
+	users       map[int64]*data.User
+	tokens      []*data.Token
+	permissions map[int64]data.Permissions
+	people      map[int64]*data.Person
+	reviews     map[int64]*data.Review
+
+	// credits holds the credits of each movie, keyed by movie ID, and watchlist holds
+	// the entries on each user's watchlist, keyed by user ID and then movie ID. The
+	// names and titles in them are left empty and looked up when they are read.
+	credits   map[int64][]*data.Credit
+	watchlist map[int64]map[int64]*data.WatchlistEntry
+
+	// rotatedAt holds the time that each rotated refresh token was used, keyed by its
+	// hash, in place of the rotated_at column.
+	rotatedAt map[string]time.Time
+
+	// The last ID handed out for each kind of record, in place of the bigserial
+	// sequences.
+	lastGenreID    int64
+	lastMovieID    int64
+	lastRevisionID int64
+	lastUserID     int64
+	lastTokenID    int64
+	lastPersonID   int64
+	lastReviewID   int64
+}
+
+// NewModels() returns a data.Models containing empty in-memory stores.
+func NewModels() data.Models {
+	s := &store{
+		genres:      map[int64]*data.Genre{},
+		movies:      map[int64]*movieRecord{},
+		revisions:   map[int64][]*data.MovieRevision{},
+		users:       map[int64]*data.User{},
+		permissions: map[int64]data.Permissions{},
+		rotatedAt:   map[string]time.Time{},
+		people:      map[int64]*data.Person{},
+		reviews:     map[int64]*data.Review{},
+		credits:     map[int64][]*data.Credit{},
+		watchlist:   map[int64]map[int64]*data.WatchlistEntry{},
+	}
+
+	return data.Models{
+		Genres:      genreStore{s},
+		Movies:      movieStore{s},
+		People:      personStore{s},
+		Permissions: permissionStore{s},
+		Reviews:     reviewStore{s},
+		Tokens:      tokenStore{s},
+		Users:       userStore{s},
+		Watchlist:   watchlistStore{s},
+	}
+}
+
+// Check at compile time that the stores implement the data package interfaces.
+var (
+	_ data.MovieStore      = movieStore{}
+	_ data.GenreStore      = genreStore{}
+	_ data.UserStore       = userStore{}
+	_ data.TokenStore      = tokenStore{}
+	_ data.PermissionStore = permissionStore{}
+	_ data.PersonStore     = personStore{}
+	_ data.ReviewStore     = reviewStore{}
+	_ data.WatchlistStore  = watchlistStore{}
+)
+
+// now() returns the current time rounded to the second, matching the precision of
+// the timestamp(0) columns in the database.
+func now() time.Time {
+	return time.Now().Round(time.Second)
+}
+
+// paginate() returns the slice of records on the page requested by the filters, along
+// with the pagination metadata, in the same way as LIMIT and OFFSET in the data
+// package. As there, the metadata is empty if there are no records on the page.
+func paginate[T any](records []T, filters data.Filters) ([]T, data.Metadata) {
+	start := (filters.Page - 1) * filters.PageSize
+	if start > len(records) {
+		start = len(records)
+	}
+
+	end := start + filters.PageSize
+	if end > len(records) {
+		end = len(records)
+	}
+
+	if start == end {
+		return records[start:end], data.Metadata{}
+	}
+
+	metadata := data.Metadata{
+		CurrentPage:  filters.Page,
+		PageSize:     filters.PageSize,
+		FirstPage:    1,
+		LastPage:     (len(records) + filters.PageSize - 1) / filters.PageSize,
+		TotalRecords: len(records),
+	}
+
+	return records[start:end], metadata
+}
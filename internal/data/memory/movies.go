@@ -0,0 +1,713 @@
+package memory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+// movieRecord is a stored movie. The genres are kept as IDs, so that renaming a genre is
+// picked up by every movie tagged with it, as with the movies_genres table.
+type movieRecord struct {
+	data.Movie
+	genreIDs  []int64
+	deletedAt *time.Time
+}
+
+type movieStore struct {
+	s *store
+}
+
+// get() returns a copy of the stored movie, with its genre names and rating filled in.
+// The caller must hold the lock.
+func (s *store) get(id int64) *data.Movie {
+	stored := s.movies[id]
+
+	movie := stored.Movie
+	movie.Genres = s.genreNames(stored.genreIDs)
+	movie.AverageRating, movie.RatingCount = s.rating(id)
+	return &movie
+}
+
+// credited() reports whether a person is credited on a movie in any role. The caller
+// must hold the lock.
+func (s *store) credited(movieID, personID int64) bool {
+	for _, credit := range s.credits[movieID] {
+		if credit.PersonID == personID {
+			return true
+		}
+	}
+	return false
+}
+
+// recordRevision() snapshots the current state of the movie into its revision history.
+// The caller must hold the lock.
+func (s *store) recordRevision(id, userID int64, action string) {
+	movie := s.get(id)
+
+	s.lastRevisionID++
+	s.revisions[id] = append(s.revisions[id], &data.MovieRevision{
+		ID:        s.lastRevisionID,
+		CreatedAt: now(),
+		MovieID:   id,
+		Version:   movie.Version,
+		UserID:    &userID,
+		Action:    action,
+		Snapshot: data.MovieSnapshot{
+			Title:   movie.Title,
+			Year:    movie.Year,
+			Runtime: movie.Runtime,
+			Genres:  movie.Genres,
+		},
+	})
+}
+
+// words() splits text into lowercase words, for matching searches.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// matchWords() returns how many of the query words appear in the text, and whether all
+// of them do.
+func matchWords(text, query string) (int, bool) {
+	textWords := map[string]bool{}
+	for _, word := range words(text) {
+		textWords[word] = true
+	}
+
+	queryWords := words(query)
+	matched := 0
+	for _, word := range queryWords {
+		if textWords[word] {
+			matched++
+		}
+	}
+
+	return matched, matched == len(queryWords)
+}
+
+// headline() wraps the words of the title which appear in the search query in <b> and
+// </b> tags, like ts_headline().
+func headline(title, search string) string {
+	queryWords := map[string]bool{}
+	for _, word := range words(search) {
+		queryWords[word] = true
+	}
+
+	var b strings.Builder
+	var word []rune
+
+	flush := func() {
+		if queryWords[strings.ToLower(string(word))] {
+			b.WriteString("<b>" + string(word) + "</b>")
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+
+	for _, r := range title {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	flush()
+
+	return b.String()
+}
+
+// filter() returns copies of the movies which aren't in the trash and match the same
+// title, genres, search and person filters as MovieModel.GetAll(), in id order. The
+// relevance and headline of each movie are set when there is a search query. The
+// caller must hold the lock.
+func (s *store) filter(title string, genres []string, search string, personID int64) []*data.Movie {
+	movies := []*data.Movie{}
+
+	for id, stored := range s.movies {
+		if stored.deletedAt != nil {
+			continue
+		}
+
+		if personID != 0 && !s.credited(id, personID) {
+			continue
+		}
+
+		movie := s.get(id)
+
+		if _, ok := matchWords(movie.Title, title); !ok {
+			continue
+		}
+
+		if !hasGenres(movie.Genres, genres) {
+			continue
+		}
+
+		if search != "" {
+			matched, ok := matchWords(movie.Title, search)
+			if !ok {
+				continue
+			}
+			if total := len(words(movie.Title)); total > 0 {
+				movie.Relevance = float32(matched) / float32(total)
+			}
+			movie.Headline = headline(movie.Title, search)
+		}
+
+		movies = append(movies, movie)
+	}
+
+	sort.Slice(movies, func(i, j int) bool {
+		return movies[i].ID < movies[j].ID
+	})
+
+	return movies
+}
+
+// hasGenres() reports whether the movie genres include every one of the wanted genres,
+// compared case-insensitively.
+func hasGenres(genres, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, genre := range genres {
+			if strings.EqualFold(genre, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// sortKey holds the value of the sort column for a movie. Titles are compared as text
+// and the other columns as numbers.
+type sortKey struct {
+	Text   string  `json:"t,omitempty"`
+	Number float64 `json:"n,omitempty"`
+}
+
+func movieSortKey(movie *data.Movie, column string) sortKey {
+	switch column {
+	case "title":
+		return sortKey{Text: movie.Title}
+	case "year":
+		return sortKey{Number: float64(movie.Year)}
+	case "runtime":
+		return sortKey{Number: float64(movie.Runtime)}
+	case "rating":
+		return sortKey{Number: movie.AverageRating}
+	case "relevance":
+		return sortKey{Number: float64(movie.Relevance)}
+	default:
+		return sortKey{Number: float64(movie.ID)}
+	}
+}
+
+// compareMovies() returns a negative number if a movie with key a and id aID sorts before
+// one with key b and id bID in the order given by the sort parameter, and a positive
+// number if it sorts after. The id is always an ascending secondary key.
+func compareMovies(a sortKey, aID int64, b sortKey, bID int64, sortParam string) int {
+	c := strings.Compare(a.Text, b.Text)
+	if c == 0 && a.Number != b.Number {
+		c = 1
+		if a.Number < b.Number {
+			c = -1
+		}
+	}
+
+	if strings.HasPrefix(sortParam, "-") {
+		c = -c
+	}
+
+	if c == 0 && aID != bID {
+		c = 1
+		if aID < bID {
+			c = -1
+		}
+	}
+
+	return c
+}
+
+func sortMovies(movies []*data.Movie, sortParam string) {
+	column := strings.TrimPrefix(sortParam, "-")
+
+	sort.Slice(movies, func(i, j int) bool {
+		a, b := movies[i], movies[j]
+		return compareMovies(movieSortKey(a, column), a.ID, movieSortKey(b, column), b.ID, sortParam) < 0
+	})
+}
+
+// cursor is the position of a keyset page boundary. Unlike the cursors issued by
+// MovieModel it isn't signed, because there is nothing to protect in a test.
+type cursor struct {
+	Sort     string  `json:"s"`
+	Filters  string  `json:"f"`
+	Key      sortKey `json:"k"`
+	ID       int64   `json:"i"`
+	Backward bool    `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodeCursor(token string) (*cursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, data.ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return nil, data.ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// setCursors() sets the NextCursor and PrevCursor fields of the metadata from the last
+// and first movies in the page. The cursors are bound to the filters with filtersHash,
+// from data.HashMovieFilters().
+func setCursors(metadata *data.Metadata, movies []*data.Movie, sortParam, filtersHash string, hasNext, hasPrev bool) {
+	if len(movies) == 0 {
+		return
+	}
+
+	column := strings.TrimPrefix(sortParam, "-")
+
+	if hasNext {
+		last := movies[len(movies)-1]
+		metadata.NextCursor = cursor{Sort: sortParam, Filters: filtersHash, Key: movieSortKey(last, column), ID: last.ID}.encode()
+	}
+
+	if hasPrev {
+		first := movies[0]
+		metadata.PrevCursor = cursor{Sort: sortParam, Filters: filtersHash, Key: movieSortKey(first, column), ID: first.ID, Backward: true}.encode()
+	}
+}
+
+func (m movieStore) GetAll(ctx context.Context, title string, genres []string, search string, personID int64, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	m.s.mu.Lock()
+	movies := m.s.filter(title, genres, search, personID)
+	m.s.mu.Unlock()
+
+	sortMovies(movies, filters.Sort)
+	filtersHash := data.HashMovieFilters(title, genres, search, personID)
+
+	if filters.Cursor == "" {
+		offset := (filters.Page - 1) * filters.PageSize
+
+		page, metadata := paginate(movies, filters)
+		setCursors(&metadata, page, filters.Sort, filtersHash, offset+len(page) < len(movies), offset > 0)
+
+		return page, metadata, nil
+	}
+
+	c, err := decodeCursor(filters.Cursor)
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+	if c.Sort != filters.Sort || c.Filters != filtersHash {
+		return nil, data.Metadata{}, data.ErrInvalidCursor
+	}
+
+	column := strings.TrimPrefix(filters.Sort, "-")
+
+	// The movies are sorted, so the ones before the cursor come first and the ones
+	// after it come last. The cursor movie itself (if it still exists) is in neither.
+	compare := func(i int) int {
+		return compareMovies(movieSortKey(movies[i], column), movies[i].ID, c.Key, c.ID, filters.Sort)
+	}
+	before := sort.Search(len(movies), func(i int) bool { return compare(i) >= 0 })
+	after := sort.Search(len(movies), func(i int) bool { return compare(i) > 0 })
+
+	var page []*data.Movie
+	var hasNext, hasPrev bool
+
+	if c.Backward {
+		start := before - filters.PageSize
+		if start < 0 {
+			start = 0
+		}
+
+		page = movies[start:before]
+		hasNext, hasPrev = len(page) > 0, start > 0
+	} else {
+		end := after + filters.PageSize
+		if end > len(movies) {
+			end = len(movies)
+		}
+
+		page = movies[after:end]
+		hasNext, hasPrev = end < len(movies), len(page) > 0
+	}
+
+	metadata := data.Metadata{PageSize: filters.PageSize}
+	setCursors(&metadata, page, filters.Sort, filtersHash, hasNext, hasPrev)
+
+	return page, metadata, nil
+}
+
+func (m movieStore) GetFacets(ctx context.Context, title string, genres []string, search string, personID int64, facets []string) (data.Facets, error) {
+	m.s.mu.Lock()
+	movies := m.s.filter(title, genres, search, personID)
+	m.s.mu.Unlock()
+
+	result := data.Facets{}
+
+	for _, facet := range facets {
+		counts := map[string]int{}
+
+		for _, movie := range movies {
+			switch facet {
+			case "genres":
+				for _, genre := range movie.Genres {
+					counts[genre]++
+				}
+			case "year":
+				counts[fmt.Sprintf("%ds", movie.Year/10*10)]++
+			default:
+				return nil, fmt.Errorf("unknown facet: %s", facet)
+			}
+		}
+
+		buckets := []data.FacetBucket{}
+		for value, count := range counts {
+			buckets = append(buckets, data.FacetBucket{Value: value, Count: count})
+		}
+
+		sort.Slice(buckets, func(i, j int) bool {
+			if buckets[i].Count != buckets[j].Count {
+				return buckets[i].Count > buckets[j].Count
+			}
+			return buckets[i].Value < buckets[j].Value
+		})
+
+		result[facet] = buckets
+	}
+
+	return result, nil
+}
+
+func (m movieStore) Insert(ctx context.Context, movie *data.Movie, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	genreIDs, err := m.s.genreIDs(movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	m.s.insert(movie, genreIDs, userID)
+	return nil
+}
+
+// insert() stores a new movie with the given genres and records its first revision.
+// The ID, created at time, version and canonical genre names of the movie are set.
+// The caller must hold the lock.
+func (s *store) insert(movie *data.Movie, genreIDs []int64, userID int64) {
+	s.lastMovieID++
+	movie.ID = s.lastMovieID
+	movie.CreatedAt = now()
+	movie.Version = 1
+	movie.Genres = s.genreNames(genreIDs)
+
+	stored := &movieRecord{Movie: *movie, genreIDs: genreIDs}
+	stored.Genres = nil
+	s.movies[movie.ID] = stored
+
+	s.recordRevision(movie.ID, userID, "insert")
+}
+
+func (m movieStore) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.movies[id]
+	if !ok || stored.deletedAt != nil {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return m.s.get(id), nil
+}
+
+func (m movieStore) Update(ctx context.Context, movie *data.Movie, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.movies[movie.ID]
+	if !ok || stored.deletedAt != nil || stored.Version != movie.Version {
+		return data.ErrEditConflict
+	}
+
+	genreIDs, err := m.s.genreIDs(movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	stored.Title = movie.Title
+	stored.Year = movie.Year
+	stored.Runtime = movie.Runtime
+	stored.Version++
+	stored.genreIDs = genreIDs
+
+	m.s.recordRevision(movie.ID, userID, "update")
+
+	movie.Version = stored.Version
+	movie.Genres = m.s.genreNames(genreIDs)
+	return nil
+}
+
+func (m movieStore) Delete(ctx context.Context, id int64, version int32, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.movies[id]
+	if !ok || stored.deletedAt != nil || stored.Version != version {
+		return data.ErrEditConflict
+	}
+
+	deletedAt := now()
+	stored.deletedAt = &deletedAt
+	stored.Version++
+
+	m.s.recordRevision(id, userID, "delete")
+	return nil
+}
+
+func (m movieStore) GetTrash(ctx context.Context, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	m.s.mu.Lock()
+
+	movies := []*data.Movie{}
+	for id, stored := range m.s.movies {
+		if stored.deletedAt != nil {
+			movie := m.s.get(id)
+			deletedAt := *stored.deletedAt
+			movie.DeletedAt = &deletedAt
+			movies = append(movies, movie)
+		}
+	}
+
+	m.s.mu.Unlock()
+
+	column := strings.TrimPrefix(filters.Sort, "-")
+
+	key := func(movie *data.Movie) sortKey {
+		switch column {
+		case "title":
+			return sortKey{Text: movie.Title}
+		case "deleted_at":
+			return sortKey{Number: float64(movie.DeletedAt.Unix())}
+		default:
+			return sortKey{Number: float64(movie.ID)}
+		}
+	}
+
+	sort.Slice(movies, func(i, j int) bool {
+		a, b := movies[i], movies[j]
+		return compareMovies(key(a), a.ID, key(b), b.ID, filters.Sort) < 0
+	})
+
+	page, metadata := paginate(movies, filters)
+	return page, metadata, nil
+}
+
+func (m movieStore) Restore(ctx context.Context, id, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.movies[id]
+	if !ok || stored.deletedAt == nil {
+		return data.ErrRecordNotFound
+	}
+
+	stored.deletedAt = nil
+	stored.Version++
+
+	m.s.recordRevision(id, userID, "restore")
+	return nil
+}
+
+func (m movieStore) PurgeTrash(ctx context.Context, retention time.Duration) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+	purged := int64(0)
+
+	for id, stored := range m.s.movies {
+		if stored.deletedAt != nil && stored.deletedAt.Before(cutoff) {
+			m.s.purge(id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// purge() permanently deletes a movie along with its credits, reviews and watchlist
+// entries, which the database removes with ON DELETE CASCADE. The revision history is
+// kept, as it is in the database. The caller must hold the lock.
+func (s *store) purge(id int64) {
+	delete(s.movies, id)
+	delete(s.credits, id)
+
+	for reviewID, review := range s.reviews {
+		if review.MovieID == id {
+			delete(s.reviews, reviewID)
+		}
+	}
+
+	for _, entries := range s.watchlist {
+		delete(entries, id)
+	}
+}
+
+// Suggest() returns titles which start with the prefix, or which have a word starting
+// with it, scored by the proportion of the title that the prefix covers.
+func (m movieStore) Suggest(ctx context.Context, prefix string, limit int) ([]*data.MovieSuggestion, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	lowered := strings.ToLower(prefix)
+	suggestions := []*data.MovieSuggestion{}
+
+	for _, stored := range m.s.movies {
+		if stored.deletedAt != nil {
+			continue
+		}
+
+		title := strings.ToLower(stored.Title)
+		matched := strings.HasPrefix(title, lowered)
+
+		for _, word := range words(title) {
+			matched = matched || strings.HasPrefix(word, lowered)
+		}
+
+		if !matched {
+			continue
+		}
+
+		suggestions = append(suggestions, &data.MovieSuggestion{
+			ID:    stored.ID,
+			Title: stored.Title,
+			Score: float32(len(prefix)) / float32(len(stored.Title)),
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.ID < b.ID
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
+
+func (m movieStore) GetRevisions(ctx context.Context, movieID int64, filters data.Filters) ([]*data.MovieRevision, data.Metadata, error) {
+	m.s.mu.Lock()
+
+	revisions := []*data.MovieRevision{}
+	for _, revision := range m.s.revisions[movieID] {
+		r := *revision
+		revisions = append(revisions, &r)
+	}
+
+	m.s.mu.Unlock()
+
+	sort.Slice(revisions, func(i, j int) bool {
+		if strings.HasPrefix(filters.Sort, "-") {
+			return revisions[i].Version > revisions[j].Version
+		}
+		return revisions[i].Version < revisions[j].Version
+	})
+
+	page, metadata := paginate(revisions, filters)
+	return page, metadata, nil
+}
+
+func (m movieStore) GetRevision(ctx context.Context, movieID int64, version int32) (*data.MovieRevision, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, revision := range m.s.revisions[movieID] {
+		if revision.Version == version {
+			r := *revision
+			return &r, nil
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
+
+// Import() inserts all of the movies, or none of them if any has a genre which isn't in
+// the vocabulary.
+func (m movieStore) Import(ctx context.Context, movies []*data.Movie, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	genreIDs := make([][]int64, len(movies))
+
+	for i, movie := range movies {
+		ids, err := m.s.genreIDs(movie.Genres)
+		if err != nil {
+			return err
+		}
+		genreIDs[i] = ids
+	}
+
+	for i, movie := range movies {
+		m.s.insert(movie, genreIDs[i], userID)
+	}
+
+	return nil
+}
+
+// Export() calls fn for each matching movie in id order. The movies are copied before
+// the lock is released, so fn can take as long as it likes without blocking the other
+// stores, and sees a consistent snapshot like the read-only transaction in
+// MovieModel.Export().
+func (m movieStore) Export(ctx context.Context, title string, genres []string, search string, personID int64, fn func(movie *data.Movie) error) error {
+	m.s.mu.Lock()
+	movies := m.s.filter(title, genres, search, personID)
+	m.s.mu.Unlock()
+
+	for _, movie := range movies {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn(movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
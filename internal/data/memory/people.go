@@ -0,0 +1,164 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+type personStore struct {
+	s *store
+}
+
+// GetAll() returns a page of the people whose name contains every word of the name
+// filter, like the title filter on movies.
+func (m personStore) GetAll(ctx context.Context, name string, filters data.Filters) ([]*data.Person, data.Metadata, error) {
+	m.s.mu.Lock()
+
+	people := []*data.Person{}
+	for _, person := range m.s.people {
+		if _, ok := matchWords(person.Name, name); !ok {
+			continue
+		}
+
+		p := *person
+		people = append(people, &p)
+	}
+
+	m.s.mu.Unlock()
+
+	key := func(person *data.Person) sortKey {
+		if strings.TrimPrefix(filters.Sort, "-") == "name" {
+			return sortKey{Text: person.Name}
+		}
+		return sortKey{Number: float64(person.ID)}
+	}
+
+	sort.Slice(people, func(i, j int) bool {
+		a, b := people[i], people[j]
+		return compareMovies(key(a), a.ID, key(b), b.ID, filters.Sort) < 0
+	})
+
+	page, metadata := paginate(people, filters)
+	return page, metadata, nil
+}
+
+func (m personStore) Get(ctx context.Context, id int64) (*data.Person, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	person, ok := m.s.people[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	p := *person
+	return &p, nil
+}
+
+func (m personStore) Insert(ctx context.Context, person *data.Person) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	m.s.lastPersonID++
+	person.ID = m.s.lastPersonID
+	person.CreatedAt = now()
+	person.Version = 1
+
+	p := *person
+	m.s.people[p.ID] = &p
+	return nil
+}
+
+func (m personStore) Update(ctx context.Context, person *data.Person) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.people[person.ID]
+	if !ok || stored.Version != person.Version {
+		return data.ErrEditConflict
+	}
+
+	stored.Name = person.Name
+	stored.Version++
+
+	person.Version = stored.Version
+	return nil
+}
+
+// Delete() removes a person, along with all of their movie credits.
+func (m personStore) Delete(ctx context.Context, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.people[id]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.s.people, id)
+
+	for movieID, credits := range m.s.credits {
+		kept := []*data.Credit{}
+		for _, credit := range credits {
+			if credit.PersonID != id {
+				kept = append(kept, credit)
+			}
+		}
+		m.s.credits[movieID] = kept
+	}
+
+	return nil
+}
+
+// GetCredits() returns the cast and crew of a movie, in billing order, with the names
+// of the people filled in.
+func (m personStore) GetCredits(ctx context.Context, movieID int64) ([]*data.Credit, error) {
+	m.s.mu.Lock()
+
+	credits := []*data.Credit{}
+	for _, credit := range m.s.credits[movieID] {
+		c := *credit
+		c.Name = m.s.people[c.PersonID].Name
+		credits = append(credits, &c)
+	}
+
+	m.s.mu.Unlock()
+
+	sort.Slice(credits, func(i, j int) bool {
+		a, b := credits[i], credits[j]
+		if a.BillingOrder != b.BillingOrder {
+			return a.BillingOrder < b.BillingOrder
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.PersonID < b.PersonID
+	})
+
+	return credits, nil
+}
+
+// SetCredits() replaces the cast and crew of a movie. If any of the credits refer to a
+// person who doesn't exist data.ErrUnknownPerson is returned and the existing credits
+// are left untouched.
+func (m personStore) SetCredits(ctx context.Context, movieID int64, credits []*data.Credit) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored := make([]*data.Credit, len(credits))
+
+	for i, credit := range credits {
+		if _, ok := m.s.people[credit.PersonID]; !ok {
+			return data.ErrUnknownPerson
+		}
+
+		c := *credit
+		c.Name = ""
+		stored[i] = &c
+	}
+
+	m.s.credits[movieID] = stored
+	return nil
+}
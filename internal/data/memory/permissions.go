@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+type permissionStore struct {
+	s *store
+}
+
+func (m permissionStore) GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var permissions data.Permissions
+	return append(permissions, m.s.permissions[userID]...), nil
+}
+
+// AddForUser() skips codes which aren't in PermissionCodes, and returns an error if the
+// user doesn't exist or already has one of the permissions, in the same way as the
+// constraints on the users_permissions table.
+func (m permissionStore) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.users[userID]; !ok {
+		return fmt.Errorf("permission user %d does not exist", userID)
+	}
+
+	permissions := m.s.permissions[userID]
+
+	for _, code := range codes {
+		if !data.Permissions(PermissionCodes).Include(code) {
+			continue
+		}
+		if permissions.Include(code) {
+			return fmt.Errorf("user %d already has permission %q", userID, code)
+		}
+		permissions = append(permissions, code)
+	}
+
+	m.s.permissions[userID] = permissions
+	return nil
+}
@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+type reviewStore struct {
+	s *store
+}
+
+// rating() returns the average rating of a movie, rounded to two decimal places, and
+// the number of reviews it has. Rather than keeping running totals like ReviewModel,
+// it adds up the reviews each time. The caller must hold the lock.
+func (s *store) rating(movieID int64) (float64, int32) {
+	var sum, count int64
+
+	for _, review := range s.reviews {
+		if review.MovieID == movieID {
+			sum += int64(review.Rating)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, 0
+	}
+
+	return math.Round(float64(sum)/float64(count)*100) / 100, int32(count)
+}
+
+func (m reviewStore) GetAllForMovie(ctx context.Context, movieID int64, filters data.Filters) ([]*data.Review, data.Metadata, error) {
+	m.s.mu.Lock()
+
+	reviews := []*data.Review{}
+	for _, review := range m.s.reviews {
+		if review.MovieID == movieID {
+			r := *review
+			reviews = append(reviews, &r)
+		}
+	}
+
+	m.s.mu.Unlock()
+
+	key := func(review *data.Review) sortKey {
+		if strings.TrimPrefix(filters.Sort, "-") == "rating" {
+			return sortKey{Number: float64(review.Rating)}
+		}
+		return sortKey{Number: float64(review.CreatedAt.Unix())}
+	}
+
+	sort.Slice(reviews, func(i, j int) bool {
+		a, b := reviews[i], reviews[j]
+		return compareMovies(key(a), a.ID, key(b), b.ID, filters.Sort) < 0
+	})
+
+	page, metadata := paginate(reviews, filters)
+	return page, metadata, nil
+}
+
+func (m reviewStore) Get(ctx context.Context, movieID, id int64) (*data.Review, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	review, ok := m.s.reviews[id]
+	if !ok || review.MovieID != movieID {
+		return nil, data.ErrRecordNotFound
+	}
+
+	r := *review
+	return &r, nil
+}
+
+// Insert() adds a review. As with ReviewModel, each user can only review a movie once,
+// and movies in the trash can't be reviewed.
+func (m reviewStore) Insert(ctx context.Context, review *data.Review) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	movie, ok := m.s.movies[review.MovieID]
+	if !ok || movie.deletedAt != nil {
+		return data.ErrRecordNotFound
+	}
+
+	for _, existing := range m.s.reviews {
+		if existing.MovieID == review.MovieID && existing.UserID == review.UserID {
+			return data.ErrDuplicateReview
+		}
+	}
+
+	m.s.lastReviewID++
+	review.ID = m.s.lastReviewID
+	review.CreatedAt = now()
+	review.UpdatedAt = review.CreatedAt
+	review.Version = 1
+
+	r := *review
+	m.s.reviews[r.ID] = &r
+	return nil
+}
+
+func (m reviewStore) Update(ctx context.Context, review *data.Review) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.reviews[review.ID]
+	if !ok || stored.Version != review.Version {
+		return data.ErrEditConflict
+	}
+
+	stored.Rating = review.Rating
+	stored.Body = review.Body
+	stored.UpdatedAt = now()
+	stored.Version++
+
+	review.UpdatedAt = stored.UpdatedAt
+	review.Version = stored.Version
+	return nil
+}
+
+func (m reviewStore) Delete(ctx context.Context, movieID, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	review, ok := m.s.reviews[id]
+	if !ok || review.MovieID != movieID {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.s.reviews, id)
+	return nil
+}
@@ -0,0 +1,254 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+type tokenStore struct {
+	s *store
+}
+
+func (m tokenStore) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	token, err := data.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// Insert() returns an error if the user doesn't exist, in the same way as the foreign
+// key on the tokens table.
+func (m tokenStore) Insert(ctx context.Context, token *data.Token) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.users[token.UserID]; !ok {
+		return fmt.Errorf("token user %d does not exist", token.UserID)
+	}
+
+	m.s.lastTokenID++
+	token.ID = m.s.lastTokenID
+	token.CreatedAt = now()
+	token.LastUsedAt = token.CreatedAt
+
+	t := *token
+	m.s.tokens = append(m.s.tokens, &t)
+	return nil
+}
+
+func (m tokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	kept := m.s.tokens[:0]
+	for _, token := range m.s.tokens {
+		if token.Scope != scope || token.UserID != userID {
+			kept = append(kept, token)
+		}
+	}
+	m.s.tokens = kept
+
+	return nil
+}
+
+// DeleteByHash() also deletes the rest of the token's family, like the PostgreSQL
+// model.
+func (m tokenStore) DeleteByHash(ctx context.Context, hash []byte) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var family string
+	for _, token := range m.s.tokens {
+		if string(token.Hash) == string(hash) {
+			family = token.Family
+		}
+	}
+
+	kept := m.s.tokens[:0]
+	for _, token := range m.s.tokens {
+		if string(token.Hash) != string(hash) && (family == "" || token.Family != family) {
+			kept = append(kept, token)
+		}
+	}
+	m.s.tokens = kept
+
+	return nil
+}
+
+// GetSessions() only includes the latest token in each family, like the PostgreSQL
+// model.
+func (m tokenStore) GetSessions(ctx context.Context, userID int64) ([]*data.Session, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	latest := map[string]*data.Token{}
+
+	for _, token := range m.s.tokens {
+		if token.UserID != userID || token.Scope != data.ScopeAuthentication || !token.Expiry.After(time.Now()) {
+			continue
+		}
+
+		key := token.Family
+		if key == "" {
+			key = strconv.FormatInt(token.ID, 10)
+		}
+
+		if previous, ok := latest[key]; !ok || token.ID > previous.ID {
+			latest[key] = token
+		}
+	}
+
+	sessions := []*data.Session{}
+
+	for _, token := range latest {
+		sessions = append(sessions, &data.Session{
+			ID:         token.ID,
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+			Expiry:     token.Expiry,
+			IP:         token.IP,
+			UserAgent:  token.UserAgent,
+			Hash:       token.Hash,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsedAt.Equal(sessions[j].LastUsedAt) {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (m tokenStore) DeleteSession(ctx context.Context, userID, id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var session *data.Token
+	for _, token := range m.s.tokens {
+		if token.ID == id && token.UserID == userID && token.Scope == data.ScopeAuthentication {
+			session = token
+		}
+	}
+
+	if session == nil {
+		return data.ErrRecordNotFound
+	}
+
+	kept := m.s.tokens[:0]
+	for _, token := range m.s.tokens {
+		if token != session && (session.Family == "" || token.Family != session.Family) {
+			kept = append(kept, token)
+		}
+	}
+	m.s.tokens = kept
+
+	return nil
+}
+
+func (m tokenStore) UpdateLastUsed(ctx context.Context, hash []byte, lastUsedAt time.Time) error {
+	lastUsedAt = lastUsedAt.Round(time.Second)
+
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, token := range m.s.tokens {
+		if string(token.Hash) == string(hash) && token.LastUsedAt.Before(lastUsedAt) {
+			token.LastUsedAt = lastUsedAt
+		}
+	}
+
+	return nil
+}
+
+func (m tokenStore) GetByHash(ctx context.Context, scope string, hash []byte) (*data.Token, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, token := range m.s.tokens {
+		if string(token.Hash) == string(hash) && token.Scope == scope && token.Expiry.After(time.Now()) {
+			t := *token
+			return &t, nil
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
+
+func (m tokenStore) MarkRotated(ctx context.Context, hash []byte) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, token := range m.s.tokens {
+		if string(token.Hash) == string(hash) && !token.Rotated {
+			token.Rotated = true
+			m.s.rotatedAt[string(hash)] = now()
+			return nil
+		}
+	}
+
+	return data.ErrEditConflict
+}
+
+func (m tokenStore) DeleteFamily(ctx context.Context, userID int64, family string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	kept := m.s.tokens[:0]
+	for _, token := range m.s.tokens {
+		if token.UserID != userID || token.Family != family {
+			kept = append(kept, token)
+		}
+	}
+	m.s.tokens = kept
+
+	return nil
+}
+
+func (m tokenStore) DeleteAllForFamily(ctx context.Context, scope string, userID int64, family string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	kept := m.s.tokens[:0]
+	for _, token := range m.s.tokens {
+		if token.Scope != scope || token.UserID != userID || token.Family != family {
+			kept = append(kept, token)
+		}
+	}
+	m.s.tokens = kept
+
+	return nil
+}
+
+func (m tokenStore) DeleteExpired(ctx context.Context, rotatedRetention time.Duration) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	now := time.Now()
+	deleted := int64(0)
+
+	kept := m.s.tokens[:0]
+	for _, token := range m.s.tokens {
+		rotatedAt, rotated := m.s.rotatedAt[string(token.Hash)]
+
+		if token.Expiry.Before(now) || (rotated && rotatedAt.Before(now.Add(-rotatedRetention))) {
+			delete(m.s.rotatedAt, string(token.Hash))
+			deleted++
+			continue
+		}
+
+		kept = append(kept, token)
+	}
+	m.s.tokens = kept
+
+	return deleted, nil
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+type userStore struct {
+	s *store
+}
+
+func (m userStore) Insert(ctx context.Context, user *data.User) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if m.s.emailInUse(user.Email, 0) {
+		return data.ErrDuplicateEmail
+	}
+
+	m.s.lastUserID++
+	user.ID = m.s.lastUserID
+	user.CreatedAt = now()
+	user.Version = 1
+
+	u := *user
+	m.s.users[u.ID] = &u
+	return nil
+}
+
+func (m userStore) GetByEmail(ctx context.Context, email string) (*data.User, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, user := range m.s.users {
+		if user.Email == email {
+			u := *user
+			return &u, nil
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
+
+func (m userStore) Update(ctx context.Context, user *data.User) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return data.ErrEditConflict
+	}
+
+	if m.s.emailInUse(user.Email, user.ID) {
+		return data.ErrDuplicateEmail
+	}
+
+	user.Version++
+
+	u := *user
+	m.s.users[u.ID] = &u
+	return nil
+}
+
+func (m userStore) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*data.User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, token := range m.s.tokens {
+		if string(token.Hash) == string(tokenHash[:]) && token.Scope == tokenScope && token.Expiry.After(time.Now()) {
+			u := *m.s.users[token.UserID]
+			return &u, nil
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
+
+// emailInUse() reports whether a user other than the one with the given ID has the
+// email address. Like the UNIQUE constraint on the email column, the comparison is
+// case-sensitive. The caller must hold the lock.
+func (s *store) emailInUse(email string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && user.Email == email {
+			return true
+		}
+	}
+	return false
+}
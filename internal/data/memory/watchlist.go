@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/ynrfin/greenlight/internal/data"
+)
+
+type watchlistStore struct {
+	s *store
+}
+
+// entry() returns a copy of a user's watchlist entry, with the movie title filled in,
+// or nil if the movie isn't on their watchlist or is in the trash. The caller must hold
+// the lock.
+func (s *store) entry(userID, movieID int64) *data.WatchlistEntry {
+	stored, ok := s.watchlist[userID][movieID]
+	if !ok || s.movies[movieID].deletedAt != nil {
+		return nil
+	}
+
+	entry := *stored
+	entry.Title = s.movies[movieID].Title
+	if stored.WatchedAt != nil {
+		watchedAt := *stored.WatchedAt
+		entry.WatchedAt = &watchedAt
+	}
+	return &entry
+}
+
+func (m watchlistStore) GetAllForUser(ctx context.Context, userID int64, watched *bool, filters data.Filters) ([]*data.WatchlistEntry, data.Metadata, error) {
+	m.s.mu.Lock()
+
+	entries := []*data.WatchlistEntry{}
+	for movieID := range m.s.watchlist[userID] {
+		entry := m.s.entry(userID, movieID)
+		if entry == nil || (watched != nil && entry.Watched != *watched) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	m.s.mu.Unlock()
+
+	key := func(entry *data.WatchlistEntry) sortKey {
+		switch strings.TrimPrefix(filters.Sort, "-") {
+		case "title":
+			return sortKey{Text: entry.Title}
+		case "updated_at":
+			return sortKey{Number: float64(entry.UpdatedAt.Unix())}
+		default:
+			return sortKey{Number: float64(entry.AddedAt.Unix())}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		return compareMovies(key(a), a.MovieID, key(b), b.MovieID, filters.Sort) < 0
+	})
+
+	page, metadata := paginate(entries, filters)
+	return page, metadata, nil
+}
+
+func (m watchlistStore) Get(ctx context.Context, userID, movieID int64) (*data.WatchlistEntry, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	entry := m.s.entry(userID, movieID)
+	if entry == nil {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return entry, nil
+}
+
+// Put() adds a movie to a user's watchlist, or updates the watched and favourite state
+// if it is already there, keeping the time it was first marked as watched. It reports
+// whether a new entry was created.
+func (m watchlistStore) Put(ctx context.Context, userID int64, entry *data.WatchlistEntry) (bool, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	movie, ok := m.s.movies[entry.MovieID]
+	if !ok || movie.deletedAt != nil {
+		return false, data.ErrRecordNotFound
+	}
+
+	if m.s.watchlist[userID] == nil {
+		m.s.watchlist[userID] = map[int64]*data.WatchlistEntry{}
+	}
+
+	t := now()
+	stored, ok := m.s.watchlist[userID][entry.MovieID]
+	inserted := !ok
+
+	if inserted {
+		stored = &data.WatchlistEntry{MovieID: entry.MovieID, AddedAt: t}
+		m.s.watchlist[userID][entry.MovieID] = stored
+	}
+
+	switch {
+	case !entry.Watched:
+		stored.WatchedAt = nil
+	case stored.WatchedAt == nil:
+		stored.WatchedAt = &t
+	}
+	stored.Watched = entry.Watched
+	stored.Favourite = entry.Favourite
+	stored.UpdatedAt = t
+
+	*entry = *m.s.entry(userID, entry.MovieID)
+	return inserted, nil
+}
+
+func (m watchlistStore) Delete(ctx context.Context, userID, movieID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.watchlist[userID][movieID]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.s.watchlist[userID], movieID)
+	return nil
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -13,21 +15,106 @@ var (
 )
 
 // Create a Models struct which wraps the MovieModel. We'll add other models to this
-// like UserModel and PermissionModel, as our build progress.
+// like UserModel and PermissionModel, as our build progress. The models are held as
+// interfaces, so that they can be swapped for the in-memory stores from the
+// data/memory package in tests.
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	Genres      GenreStore
+	Movies      MovieStore
+	People      PersonStore
+	Permissions PermissionStore
+	Reviews     ReviewStore
+	Tokens      TokenStore
+	Users       UserStore
+	Watchlist   WatchlistStore
+
+	// db is the connection pool used to start transactions in WithTx(). It is nil for
+	// the copies of the models which are bound to a transaction, and for in-memory
+	// stores. The timeouts and cursorKey are passed on to the copies.
+	db        *sql.DB
+	timeouts  Timeouts
+	cursorKey []byte
+}
+
+// Timeouts holds the maximum time that the models' queries can run for. Query applies
+// to most queries, and the others to the bulk operations on movies, which can take
+// much longer. A zero timeout means defaultQueryTimeout.
+type Timeouts struct {
+	Query  time.Duration
+	Import time.Duration
+	Export time.Duration
+	Purge  time.Duration
+}
+
+// defaultQueryTimeout is used by models which were created without a query timeout.
+const defaultQueryTimeout = 3 * time.Second
+
+// withQueryTimeout() returns a copy of the parent context which is cancelled after the
+// query timeout, or sooner if the parent context is cancelled, for example because the
+// client disconnected or the server is shutting down.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 // for ease of use, we also add a New() method which returns a Models struct containing
-// the intialized MovieModel
-func NewModel(db *sql.DB) Models {
+// the intialized MovieModel. Each query that the models run is cancelled if it takes
+// longer than its timeout, and movie list cursors are signed with cursorKey.
+func NewModel(db *sql.DB, timeouts Timeouts, cursorKey []byte) Models {
+	models := newModels(db, timeouts, cursorKey)
+	models.db = db
+	return models
+}
+
+// newModels() returns the models with all of their queries run against db, which can
+// be either the connection pool or a transaction.
+func newModels(db DBTX, timeouts Timeouts, cursorKey []byte) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Genres: GenreModel{DB: db, QueryTimeout: timeouts.Query},
+		Movies: MovieModel{
+			DB:            db,
+			QueryTimeout:  timeouts.Query,
+			ImportTimeout: timeouts.Import,
+			ExportTimeout: timeouts.Export,
+			PurgeTimeout:  timeouts.Purge,
+			CursorKey:     cursorKey,
+		},
+		People:      PersonModel{DB: db, QueryTimeout: timeouts.Query},
+		Permissions: PermissionModel{DB: db, QueryTimeout: timeouts.Query},
+		Reviews:     ReviewModel{DB: db, QueryTimeout: timeouts.Query},
+		Tokens:      TokenModel{DB: db, QueryTimeout: timeouts.Query},
+		Users:       UserModel{DB: db, QueryTimeout: timeouts.Query},
+		Watchlist:   WatchlistModel{DB: db, QueryTimeout: timeouts.Query},
+		timeouts:    timeouts,
+		cursorKey:   cursorKey,
 	}
 }
+
+// WithTx() runs fn within a database transaction, passing it a copy of the models which
+// run all of their queries in that transaction. The transaction is committed if fn
+// returns nil, and rolled back if it returns an error, so that multi-step changes
+// either happen completely or not at all. Calling WithTx() on models which are already
+// bound to a transaction runs fn in the same transaction, and on in-memory stores it
+// simply calls fn.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.db == nil {
+		return fn(m)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	models := newModels(tx, m.timeouts, m.cursorKey)
+
+	err = fn(models)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+// TestNewModelTimeouts checks that each timeout reaches the model which uses it, so
+// that the bulk movie operations aren't limited by the query timeout.
+func TestNewModelTimeouts(t *testing.T) {
+	timeouts := Timeouts{
+		Query:  time.Second,
+		Import: 2 * time.Second,
+		Export: 3 * time.Second,
+		Purge:  4 * time.Second,
+	}
+
+	models := NewModel(nil, timeouts, nil)
+
+	movies, ok := models.Movies.(MovieModel)
+	if !ok {
+		t.Fatalf("got movie store %T; want MovieModel", models.Movies)
+	}
+
+	got := Timeouts{
+		Query:  movies.QueryTimeout,
+		Import: movies.ImportTimeout,
+		Export: movies.ExportTimeout,
+		Purge:  movies.PurgeTimeout,
+	}
+	if got != timeouts {
+		t.Errorf("got movie timeouts %+v; want %+v", got, timeouts)
+	}
+
+	if tokens := models.Tokens.(TokenModel); tokens.QueryTimeout != timeouts.Query {
+		t.Errorf("got token query timeout %s; want %s", tokens.QueryTimeout, timeouts.Query)
+	}
+}
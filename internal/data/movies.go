@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -11,16 +13,23 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`                // Unique integer ID for the movie
-	CreatedAt time.Time `json:"-"`                 // Timestam for when the movie is added to our database
-	Title     string    `json:"title"`             // Movie title
-	Year      int32     `json:"year,omitempty"`    // Movie release year
-	Runtime   Runtime   `json:"runtime,omitempty"` //Movie runtime (in minutes)
-	Genres    []string  `json:"genres,omitempty"`  // Slice of genres for the movie
-	Version   int32     `json:"version"`           // The version number starts at 1 and will be incremented each time when the movie information is updated
+	ID            int64      `json:"id"`                   // Unique integer ID for the movie
+	CreatedAt     time.Time  `json:"-"`                    // Timestam for when the movie is added to our database
+	Title         string     `json:"title"`                // Movie title
+	Year          int32      `json:"year,omitempty"`       // Movie release year
+	Runtime       Runtime    `json:"runtime,omitempty"`    //Movie runtime (in minutes)
+	Genres        []string   `json:"genres,omitempty"`     // Slice of genres for the movie
+	Version       int32      `json:"version"`              // The version number starts at 1 and will be incremented each time when the movie information is updated
+	AverageRating float64    `json:"average_rating"`       // Average of the review ratings, or 0 if the movie hasn't been reviewed
+	RatingCount   int32      `json:"rating_count"`         // Number of reviews of the movie
+	Relevance     float32    `json:"-"`                    // Full-text search rank, only populated by GetAll()
+	Headline      string     `json:"headline,omitempty"`   // Title snippet with the search terms highlighted, only populated when searching
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"` // When the movie was moved to the trash, only populated by GetTrash()
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// ValidateMovie() checks the movie fields. The genres must all be in the vocabulary
+// of known genre names, which is matched case-insensitively.
+func ValidateMovie(v *validator.Validator, movie *Movie, vocabulary []string) {
 
 	// Use Check() method to execute our validation checks. This will add the
 	// provided key and error message to the errors mp if the check does not evaluate
@@ -39,39 +48,127 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genre")
 
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	// Genre names are case-insensitive, so compare them in lowercase.
+	known := make(map[string]bool, len(vocabulary))
+	for _, name := range vocabulary {
+		known[strings.ToLower(name)] = true
+	}
+
+	lowered := make([]string, len(movie.Genres))
+	for i, genre := range movie.Genres {
+		lowered[i] = strings.ToLower(genre)
+		v.Check(known[lowered[i]], "genres", fmt.Sprintf("must only contain known genres (%q is unknown)", genre))
+	}
+
+	v.Check(validator.Unique(lowered), "genres", "must not contain duplicate values")
 
 }
 
+// movieListColumns is the select list shared by the list queries in GetAll(). As well as
+// the movie fields, it ranks each row against the full-text search query and generates
+// a highlighted snippet of the title. PostgreSQL defers evaluating ts_headline() until
+// after the LIMIT has been applied, so the snippets are only generated for the rows in
+// the page. It expects the search query as the $3 placeholder parameter.
+const movieListColumns = `id, created_at, title, year, runtime, ` + movieGenresColumn + `, version,
+        ` + movieAverageRatingColumn + `, rating_count,
+        ts_rank(search_vector, websearch_to_tsquery('english', $3)) AS relevance,
+        CASE WHEN $3 = '' THEN '' ELSE ts_headline('english', title, websearch_to_tsquery('english', $3)) END`
+
+// movieAverageRatingColumn calculates the average review rating of a movie, rounded to
+// two decimal places, from the running totals maintained by ReviewModel. Movies without
+// any reviews have an average of 0.
+const movieAverageRatingColumn = `COALESCE(round(rating_sum::numeric / NULLIF(rating_count, 0), 2), 0)`
+
+// movieListFilters is the WHERE clause shared by the list queries in GetAll(). The title
+// filter uses PostgreSQL full-text search (the 'simple' configuration lowercases each
+// word, so the match is case-insensitive), the genres filter only keeps movies for which
+// there is no requested genre that the movie isn't linked to (so an empty list matches
+// every movie), the search filter matches the websearch-style query against the
+// stemmed search_vector column, and the person filter keeps movies that the person is
+// credited on in any role. Each condition is skipped when the client didn't provide a
+// value. Movies in the trash are always excluded. It expects the title, genres, search
+// query and person ID as the $1, $2, $3 and $4 placeholder parameters.
+const movieListFilters = `deleted_at IS NULL
+        AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+        AND NOT EXISTS (
+            SELECT 1 FROM unnest($2::text[]) AS wanted(name)
+            WHERE NOT EXISTS (
+                SELECT 1
+                FROM movies_genres
+                INNER JOIN genres ON genres.id = movies_genres.genre_id
+                WHERE movies_genres.movie_id = movies.id
+                AND lower(genres.name) = lower(wanted.name)))
+        AND (search_vector @@ websearch_to_tsquery('english', $3) OR $3 = '')
+        AND (EXISTS (
+            SELECT 1 FROM movie_credits
+            WHERE movie_credits.movie_id = movies.id AND movie_credits.person_id = $4) OR $4 = 0)`
+
+// movieSortExpression() returns the SQL expression to sort on for a given sort column.
+// Most of the columns in the sort safelist map directly to a table column, but
+// relevance is computed from the search query and rating from the review totals.
+func movieSortExpression(column string) string {
+	switch column {
+	case "relevance":
+		return "ts_rank(search_vector, websearch_to_tsquery('english', $3))"
+	case "rating":
+		return movieAverageRatingColumn
+	default:
+		return column
+	}
+}
+
 type MovieModel struct {
-	DB *sql.DB
+	DB DBTX
+	// QueryTimeout is the maximum time each query can run for, apart from those in
+	// Import(), Export() and PurgeTrash(), which have their own timeouts.
+	QueryTimeout  time.Duration
+	ImportTimeout time.Duration
+	ExportTimeout time.Duration
+	PurgeTimeout  time.Duration
+	// CursorKey is the secret used to sign and verify keyset pagination cursors.
+	CursorKey []byte
 }
 
-// Craete a new GetAll() method which returns a slice of movies. Although we're not
-// using them right now, we've set it up to accept the various filter parameters as
-// arguments.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, error) {
-	// Construct the SQL query to retrieve all movie records.
-	query := `
-        SELECT id, created_at, title, year, runtime, genres, version
+// GetAll() returns a slice of movies matching the title, genres, full-text search and
+// person filters, sorted and paginated according to the provided Filters, along with
+// the pagination Metadata. If the filters contain a cursor, keyset pagination is used
+// instead of LIMIT/OFFSET.
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, search string, personID int64, filters Filters) ([]*Movie, Metadata, error) {
+	if filters.Cursor != "" {
+		return m.getAllByCursor(ctx, title, genres, search, personID, filters)
+	}
+
+	// Construct the SQL query to retrieve the movie records. The sort column and
+	// direction can't be placeholder parameters, so we interpolate them with
+	// fmt.Sprintf() after they have been checked against the safelist. We also sort on
+	// id as a secondary key so that the order between pages is stable. The count(*)
+	// OVER() window function returns the total number of filtered records (before
+	// LIMIT and OFFSET are applied) as an extra column on every row.
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), %s
         FROM movies
-        ORDER by id`
+        WHERE %s
+        ORDER BY %s %s, id ASC
+        LIMIT $5 OFFSET $6`, movieListColumns, movieListFilters, movieSortExpression(filters.sortColumn()), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
+	args := []any{title, pq.Array(genres), search, personID, filters.limit(), filters.offset()}
+
 	// Use QueryContext() to execute the query. This returns an sql.rows resultset
 	// containing the result.
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	// Importantly defer a call to rows.Close() to ensure that the resultset is closed
 	// before GetAll() returns.
 	defer rows.Close()
 
-	// Initialize empty slice to hold the movie data.
+	// Declare a totalRecords variable and initialize empty slice to hold the movie data.
+	totalRecords := 0
 	movies := []*Movie{}
 
 	// use rows.Next to iterate through the rows in the resultset.
@@ -81,6 +178,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		// Scan the values from the row into the Movie struct. Again, note that we're
 		// using the pq.Array() adapter on the genre field.
 		err := rows.Scan(
+			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
 			&movie.Title,
@@ -88,10 +186,14 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Relevance,
+			&movie.Headline,
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		// Addthe movie struct to the slice.
@@ -100,38 +202,233 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	// call rows.Err() to retrieve any error during iteration.
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
+	}
+
+	// Generate a Metadata struct, passing in the total record count and pagination
+	// parameters from the client.
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	// Also hand out cursors for the neighbouring pages, so that a client can switch to
+	// keyset pagination after fetching the first page in the usual way.
+	hasNext := filters.offset()+len(movies) < totalRecords
+	hasPrev := filters.offset() > 0
+
+	err = m.setCursors(&metadata, movies, filters, HashMovieFilters(title, genres, search, personID), hasNext, hasPrev)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, metadata, nil
+}
+
+// getAllByCursor() fetches the page of movies immediately after (or before, for a
+// backward cursor) the position encoded in filters.Cursor. Rather than skipping rows
+// with OFFSET, the query seeks straight to the cursor position using the sort column
+// and id, which keeps performance constant however deep the client pages and doesn't
+// skip or repeat rows when movies are inserted concurrently.
+func (m MovieModel) getAllByCursor(ctx context.Context, title string, genres []string, search string, personID int64, filters Filters) ([]*Movie, Metadata, error) {
+	// Decode the cursor, and make sure that it was issued for the same sort order and
+	// filters that the client is requesting now.
+	filtersHash := HashMovieFilters(title, genres, search, personID)
+
+	c, err := decodeCursor(m.CursorKey, filters.Cursor)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if c.Sort != filters.Sort || c.Filters != filtersHash {
+		return nil, Metadata{}, ErrInvalidCursor
+	}
+
+	column := movieSortExpression(filters.sortColumn())
+	direction := filters.sortDirection()
+
+	// Work out the comparison operators and ORDER BY directions. Going forwards we
+	// want rows that sort after the cursor in the requested order (remembering that id
+	// is always the ascending secondary key). Going backwards we flip everything, then
+	// reverse the fetched rows in Go so the page is returned in the requested order.
+	valueOp, idOp, idDirection := ">", ">", "ASC"
+	if direction == "DESC" {
+		valueOp = "<"
+	}
+	if c.Backward {
+		if valueOp == ">" {
+			valueOp, direction = "<", "DESC"
+		} else {
+			valueOp, direction = ">", "ASC"
+		}
+		idOp, idDirection = "<", "DESC"
+	}
+
+	// We fetch one more row than the page size, so we can tell whether there are any
+	// more records beyond this page without running a separate count query.
+	query := fmt.Sprintf(`
+        SELECT %[1]s
+        FROM movies
+        WHERE %[2]s
+        AND (%[3]s %[4]s $5 OR (%[3]s = $5 AND id %[5]s $6))
+        ORDER BY %[3]s %[6]s, id %[7]s
+        LIMIT $7`, movieListColumns, movieListFilters, column, valueOp, idOp, direction, idDirection)
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	args := []any{title, pq.Array(genres), search, personID, c.Value, c.ID, filters.limit() + 1}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Relevance,
+			&movie.Headline,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// If we got the extra row there are more records in the direction of travel, so
+	// drop it from the page.
+	hasMore := len(movies) > filters.limit()
+	if hasMore {
+		movies = movies[:filters.limit()]
+	}
+
+	// Having arrived from a cursor, we know there are records on the other side of it.
+	hasNext, hasPrev := hasMore, len(movies) > 0
+	if c.Backward {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+		hasNext, hasPrev = hasPrev, hasMore
+	}
+
+	// We don't know the total number of records or the page number in keyset mode, so
+	// the metadata only contains the page size and cursors.
+	metadata := Metadata{PageSize: filters.PageSize}
+
+	err = m.setCursors(&metadata, movies, filters, filtersHash, hasNext, hasPrev)
+	if err != nil {
+		return nil, Metadata{}, err
 	}
 
-	return movies, nil
+	return movies, metadata, nil
 }
 
-// Add placeholder method for inserting a new record in the movies table
-func (m MovieModel) Insert(movie *Movie) error {
+// setCursors() populates the NextCursor and PrevCursor fields of the metadata from the
+// last and first movies in the page respectively. The cursors are bound to the filters
+// with filtersHash, from HashMovieFilters().
+func (m MovieModel) setCursors(metadata *Metadata, movies []*Movie, filters Filters, filtersHash string, hasNext, hasPrev bool) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	column := filters.sortColumn()
+
+	if hasNext {
+		last := movies[len(movies)-1]
+		next := cursor{Sort: filters.Sort, Filters: filtersHash, Value: movieSortValue(last, column), ID: last.ID}
+
+		token, err := next.encode(m.CursorKey)
+		if err != nil {
+			return err
+		}
+		metadata.NextCursor = token
+	}
+
+	if hasPrev {
+		first := movies[0]
+		prev := cursor{Sort: filters.Sort, Filters: filtersHash, Value: movieSortValue(first, column), ID: first.ID, Backward: true}
+
+		token, err := prev.encode(m.CursorKey)
+		if err != nil {
+			return err
+		}
+		metadata.PrevCursor = token
+	}
+
+	return nil
+}
+
+// Add placeholder method for inserting a new record in the movies table. The userID is
+// the user making the change, which is recorded in the revision history.
+func (m MovieModel) Insert(ctx context.Context, movie *Movie, userID int64) error {
 	// Define the sql query for inserting a new record in the movies table and returning
 	// the system-generated data
 	query := `
-        INSERT INTO movies (title, year, runtime, genres)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO movies (title, year, runtime)
+        VALUES ($1, $2, $3)
         RETURNING id, created_at, version `
 
 	// create an args slice containing the values for the placeholder parameters from
 	// the movie struct. Declaring this slice immeditely next to ou SQL query helps to
 	// make it nice and clear *what values are being used where* in the query
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime}
 
-	// Create a context wit 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a context with the query timeout.
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	// Use the QueryRow() method to execute the SQL query on our connection pool,
-	// passing in the args slice as a variadic parameter and scanning the system-
-	// generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	// The movie and its genre links are written in a single transaction, so that a
+	// movie is never saved without its genres.
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Use the QueryRow() method to execute the SQL query, passing in the args slice as
+	// a variadic parameter and scanning the system-generated id, created_at and
+	// version values into the movie struct.
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	genres, err := setMovieGenres(ctx, tx, movie.ID, movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	err = recordMovieRevision(ctx, tx, userID, "insert", movie.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	movie.Genres = genres
+	return nil
 }
 
 // Add placeholder method for fetching a specific record from the movies table
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	// The PostgreSQL bigserial type that we're using for the movie  ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will ahve ID values
 	// less than that. TO avoid making an unnescessary database call, we take a shortcut
@@ -142,16 +439,17 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	// Define the SQL query for retrieving the movie data.
 	query := `
-        SELECT  id, created_at, title, year, runtime, genres, version
+        SELECT  id, created_at, title, year, runtime, ` + movieGenresColumn + `, version,
+            ` + movieAverageRatingColumn + `, rating_count
         FROM movies
-        WHERE id = $1 `
+        WHERE id = $1 AND deleted_at IS NULL `
 
 	var movie Movie
 
-	// Use the context.WithTimeout() function to create a contet.Context which carries a
-	// 3-second timeout deadiline. Note that we're using the empty context.Background()
-	// as the 'parent' context
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Use the withQueryTimeout() helper to create a context.Context which carries the
+	// query timeout deadline. Note that the context passed in by the caller is the
+	// 'parent' context, so the query is also cancelled if the client disconnects
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 
 	// Importantly, use devefr to make sure that we cancel the context before the Get()
 	// method returns.
@@ -160,7 +458,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// Execute the query using the QueryRow() method, passing in the provided id value
 	// as a placeholder parameter, and scan the response data into the fields of the
 	// Movie struct. Importantly, notice that we need to convert the scan target for the
-	// genres column using the pq.Array() adapter function again.
+	// genres array using the pq.Array() adapter function again.
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
@@ -169,6 +467,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingCount,
 	)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return
@@ -185,14 +485,15 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-// Add placeholder method for updating a specific record from the movies table
-func (m MovieModel) Update(movie *Movie) error {
+// Add placeholder method for updating a specific record from the movies table. The
+// userID is the user making the change, which is recorded in the revision history.
+func (m MovieModel) Update(ctx context.Context, movie *Movie, userID int64) error {
 	// Declare the SQL query for updating the record and returning the new version
 	// number
 	query := `
         UPDATE movies
-        SET title = $1, year = $2, runtime= $3, genres = $4, version = version + 1
-        WHERE id = $5 AND version = $6
+        SET title = $1, year = $2, runtime= $3, version = version + 1
+        WHERE id = $4 AND version = $5 AND deleted_at IS NULL
         RETURNING version
     `
 
@@ -201,18 +502,25 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
-		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version,
 	}
 
-	// Create a context wit 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a context with the query timeout.
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
+	// Update the movie and replace its genre links in a single transaction.
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// use the QueryRow() method to execute the qery, passing in the args slice as a
 	// variadic parameter and scanning the new version value into the movie struct
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	var version int32
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&version)
 
 	if err != nil {
 		switch {
@@ -222,29 +530,60 @@ func (m MovieModel) Update(movie *Movie) error {
 			return err
 		}
 	}
+
+	genres, err := setMovieGenres(ctx, tx, movie.ID, movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	err = recordMovieRevision(ctx, tx, userID, "update", movie.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	movie.Version = version
+	movie.Genres = genres
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete() moves a movie to the trash by setting its deleted_at timestamp. The movie
+// is hidden from every other read in MovieModel, but can be brought back with Restore()
+// until PurgeTrash() removes it permanently. The version is incremented, so that the
+// deletion gets its own entry in the revision history. Like Update(), the movie is only
+// deleted if it is still at the expected version, and ErrEditConflict is returned if
+// it isn't.
+func (m MovieModel) Delete(ctx context.Context, id int64, version int32, userID int64) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
+	// Construct the SQL query to soft delete the record.
 	query := `
-        DELETE FROM movies
-        where id = $1
+        UPDATE movies
+        SET deleted_at = NOW(), version = version + 1
+        WHERE id = $1 AND version = $2 AND deleted_at IS NULL
     `
 
-	// Create a context wit 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a context with the query timeout.
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Execute the SQL query using the Exec() method, passing in the id variable as
 	// the value for the placeholder parameter. The Exec() method erturs a sql.Result
 	// object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := tx.ExecContext(ctx, query, id, version)
 	if err != nil {
 		return err
 	}
@@ -255,11 +594,192 @@ func (m MovieModel) Delete(id int64) error {
 	if err != nil {
 		return err
 	}
-	// If no rows were affected, we know that the movies table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
+	// If no rows were affected, the movie was changed or deleted since the caller read
+	// it. In that case we return an ErrEditConflict error.
 	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	err = recordMovieRevision(ctx, tx, userID, "delete", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTrash() returns a page of the movies which have been moved to the trash.
+func (m MovieModel) GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, title, year, runtime, %s, version,
+            %s, rating_count, deleted_at
+        FROM movies
+        WHERE deleted_at IS NOT NULL
+        ORDER BY %s %s, id ASC
+        LIMIT $1 OFFSET $2`, movieGenresColumn, movieAverageRatingColumn, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Restore() takes a movie back out of the trash. If the movie doesn't exist, or isn't
+// in the trash, ErrRecordNotFound is returned. Like Delete(), this increments the
+// version and is recorded in the revision history.
+func (m MovieModel) Restore(ctx context.Context, id, userID int64) error {
+	if id < 1 {
 		return ErrRecordNotFound
 	}
-	return nil
+
+	query := `
+        UPDATE movies
+        SET deleted_at = NULL, version = version + 1
+        WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = recordMovieRevision(ctx, tx, userID, "restore", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeTrash() permanently deletes the movies which have been in the trash for longer
+// than the retention period, returning the number of movies deleted. Their credits,
+// reviews and watchlist entries are removed along with them by the ON DELETE CASCADE
+// foreign keys, but their revisions are kept as a record of what was deleted.
+func (m MovieModel) PurgeTrash(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `
+        DELETE FROM movies
+        WHERE deleted_at < $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.PurgeTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// MovieSuggestion holds a movie title matched by Suggest(), along with its trigram
+// similarity score to the prefix.
+type MovieSuggestion struct {
+	ID    int64   `json:"id"`
+	Title string  `json:"title"`
+	Score float32 `json:"score"`
+}
+
+// Suggest() returns up to limit movie titles which fuzzily match the given prefix,
+// ranked by trigram word similarity so that typos like "teh matrix" still find
+// "The Matrix". Titles which literally start with the prefix are also included even
+// if they score below the similarity threshold, as is usually the case for very short
+// prefixes.
+func (m MovieModel) Suggest(ctx context.Context, prefix string, limit int) ([]*MovieSuggestion, error) {
+	query := `
+        SELECT id, title, word_similarity($1, title) AS score
+        FROM movies
+        WHERE deleted_at IS NULL AND ($1 <% title OR title ILIKE $2)
+        ORDER BY score DESC, title ASC, id ASC
+        LIMIT $3`
+
+	args := []any{prefix, escapeLike(prefix) + "%", limit}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+
+	for rows.Next() {
+		var suggestion MovieSuggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// escapeLike() escapes the characters which have a special meaning in a LIKE pattern,
+// so that user input is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
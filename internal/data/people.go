@@ -0,0 +1,277 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/validator"
+)
+
+var ErrUnknownPerson = errors.New("unknown person")
+
+// CreditRoles holds the roles that a person can be credited with on a movie.
+var CreditRoles = []string{"director", "writer", "actor"}
+
+// Person is someone who worked on one or more movies, as a director, writer or actor.
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+}
+
+// Credit links a person to a movie in a specific role. Character is only used for
+// actors, and BillingOrder controls the order in which credits are listed.
+type Credit struct {
+	PersonID     int64  `json:"person_id"`
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+}
+
+func ValidateCredits(v *validator.Validator, credits []*Credit) {
+	v.Check(credits != nil, "credits", "must be provided")
+	v.Check(len(credits) <= 500, "credits", "must not contain more than 500 credits")
+
+	keys := make([]string, len(credits))
+
+	for i, credit := range credits {
+		key := fmt.Sprintf("credits[%d]", i)
+
+		v.Check(credit.PersonID > 0, key, "person_id must be provided")
+		v.Check(validator.PermittedValue(credit.Role, CreditRoles...), key, "role must be one of director, writer or actor")
+		v.Check(credit.Character == "" || credit.Role == "actor", key, "character can only be set for actors")
+		v.Check(len(credit.Character) <= 500, key, "character must not be more than 500 bytes long")
+		v.Check(credit.BillingOrder >= 0, key, "billing_order must not be negative")
+
+		keys[i] = fmt.Sprintf("%d/%s/%s", credit.PersonID, credit.Role, credit.Character)
+	}
+
+	v.Check(validator.Unique(keys), "credits", "must not contain duplicate credits")
+}
+
+type PersonModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// GetAll() returns a page of people whose name matches the name filter, which works
+// in the same way as the movie title filter.
+func (m PersonModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, name, version
+        FROM people
+        WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(&totalRecords, &person.ID, &person.CreatedAt, &person.Name, &person.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+func (m PersonModel) Get(ctx context.Context, id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, created_at, name, version
+        FROM people
+        WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&person.ID, &person.CreatedAt, &person.Name, &person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+func (m PersonModel) Insert(ctx context.Context, person *Person) error {
+	query := `
+        INSERT INTO people (name)
+        VALUES ($1)
+        RETURNING id, created_at, version`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, person.Name).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Update(ctx context.Context, person *Person) error {
+	query := `
+        UPDATE people
+        SET name = $1, version = version + 1
+        WHERE id = $2 AND version = $3
+        RETURNING version`
+
+	args := []any{person.Name, person.ID, person.Version}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete() removes a person, along with all of their movie credits.
+func (m PersonModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM people
+        WHERE id = $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetCredits() returns the cast and crew of a movie, in billing order.
+func (m PersonModel) GetCredits(ctx context.Context, movieID int64) ([]*Credit, error) {
+	query := `
+        SELECT people.id, people.name, movie_credits.role, movie_credits.character, movie_credits.billing_order
+        FROM movie_credits
+        INNER JOIN people ON people.id = movie_credits.person_id
+        WHERE movie_credits.movie_id = $1
+        ORDER BY movie_credits.billing_order, people.name, people.id`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(&credit.PersonID, &credit.Name, &credit.Role, &credit.Character, &credit.BillingOrder)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// SetCredits() replaces the cast and crew of a movie in a single transaction. If any of
+// the credits refer to a person who doesn't exist ErrUnknownPerson is returned and the
+// existing credits are left untouched.
+func (m PersonModel) SetCredits(ctx context.Context, movieID int64, credits []*Credit) error {
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+        VALUES ($1, $2, $3, $4, $5)`
+
+	for _, credit := range credits {
+		args := []any{movieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: insert or update on table "movie_credits" violates foreign key constraint "movie_credits_person_id_fkey"`:
+				return ErrUnknownPerson
+			default:
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
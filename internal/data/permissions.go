@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"github.com/lib/pq"
@@ -25,12 +24,13 @@ func (p Permissions) Include(code string) bool {
 
 // Define the PermissionModel type.
 type PermissionModel struct {
-	DB *sql.DB
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 // The GetAllForUser() method returns al permission codes for a specific user in a
 // Permissions slice.
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
         SELECT permissions.code
         FROM permissions
@@ -38,7 +38,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
         INNER JOIN users ON users_permissions.user_id = users.id
         WHERE users.id = $1 `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -70,12 +70,12 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 // Add the provided permission codes for a specific user. Notice that we're using a
 // variadic parameter for the codes so that we can assign multiple permissions in a
 // single call.
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
         INSERT INTO users_permissions
         SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2) `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
@@ -0,0 +1,276 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ynrfin/greenlight/internal/validator"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Review holds a user's rating (from 1 to 10) of a movie, along with an optional
+// written review.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Rating    int32     `json:"rating"`
+	Body      string    `json:"body,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", "must be provided")
+	v.Check(review.Rating >= 1 && review.Rating <= 10, "rating", "must be between 1 and 10")
+
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// ReviewModel manages reviews. Every method which changes a rating also updates the
+// running rating_count and rating_sum totals on the movie in the same transaction, so
+// that the movie's average rating always agrees with its reviews. The totals are
+// adjusted with relative updates, rather than recalculated, so that concurrent reviews
+// of the same movie don't overwrite each other's changes.
+type ReviewModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// GetAllForMovie() returns a page of reviews for a movie.
+func (m ReviewModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, updated_at, movie_id, user_id, rating, body, version
+        FROM reviews
+        WHERE movie_id = $1
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// Get() returns a review of a specific movie.
+func (m ReviewModel) Get(ctx context.Context, movieID, id int64) (*Review, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, created_at, updated_at, movie_id, user_id, rating, body, version
+        FROM reviews
+        WHERE id = $1 AND movie_id = $2`
+
+	var review Review
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// Insert() adds a review and includes its rating in the movie's totals. Each user can
+// only review a movie once, so ErrDuplicateReview is returned if they already have.
+// Movies in the trash can't be reviewed, so ErrRecordNotFound is returned for them as
+// well as for movies which don't exist.
+func (m ReviewModel) Insert(ctx context.Context, review *Review) error {
+	query := `
+        INSERT INTO reviews (movie_id, user_id, rating, body)
+        SELECT id, $2::bigint, $3::integer, $4::text
+        FROM movies
+        WHERE id = $1 AND deleted_at IS NULL
+        RETURNING id, created_at, updated_at, version`
+
+	args := []any{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	err = adjustMovieRating(ctx, tx, review.MovieID, 1, int64(review.Rating))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update() changes the rating and body of a review, and adjusts the movie's rating
+// total by the difference between the old and new ratings.
+func (m ReviewModel) Update(ctx context.Context, review *Review) error {
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the review and read the rating that is currently included in the movie's
+	// total. If the version doesn't match, someone else has changed the review since
+	// the client read it.
+	var previousRating int32
+
+	err = tx.QueryRowContext(ctx, `
+        SELECT rating
+        FROM reviews
+        WHERE id = $1 AND version = $2
+        FOR UPDATE`, review.ID, review.Version).Scan(&previousRating)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query := `
+        UPDATE reviews
+        SET rating = $1, body = $2, updated_at = NOW(), version = version + 1
+        WHERE id = $3
+        RETURNING updated_at, version`
+
+	args := []any{review.Rating, review.Body, review.ID}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		return err
+	}
+
+	err = adjustMovieRating(ctx, tx, review.MovieID, 0, int64(review.Rating-previousRating))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete() removes a review of a movie and takes its rating out of the movie's totals.
+func (m ReviewModel) Delete(ctx context.Context, movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var rating int32
+
+	err = tx.QueryRowContext(ctx, `
+        DELETE FROM reviews
+        WHERE id = $1 AND movie_id = $2
+        RETURNING rating`, id, movieID).Scan(&rating)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = adjustMovieRating(ctx, tx, movieID, -1, -int64(rating))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// adjustMovieRating() adds the given deltas to the rating count and sum of a movie.
+func adjustMovieRating(ctx context.Context, tx DBTX, movieID int64, countDelta int, sumDelta int64) error {
+	query := `
+        UPDATE movies
+        SET rating_count = rating_count + $1, rating_sum = rating_sum + $2
+        WHERE id = $3`
+
+	_, err := tx.ExecContext(ctx, query, countDelta, sumDelta, movieID)
+	return err
+}
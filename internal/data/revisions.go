@@ -0,0 +1,175 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieSnapshot holds the editable fields of a movie as they were at a given version.
+type MovieSnapshot struct {
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime Runtime  `json:"runtime"`
+	Genres  []string `json:"genres"`
+}
+
+// MovieRevision records a change to a movie. Every insert, update, delete and restore
+// increments the movie version, so each revision has a unique version number. UserID
+// is nil for revisions made before history was recorded, or by a user who has since
+// been deleted.
+type MovieRevision struct {
+	ID        int64         `json:"-"`
+	CreatedAt time.Time     `json:"created_at"`
+	MovieID   int64         `json:"movie_id"`
+	Version   int32         `json:"version"`
+	UserID    *int64        `json:"user_id"`
+	Action    string        `json:"action"`
+	Snapshot  MovieSnapshot `json:"snapshot"`
+}
+
+// recordMovieRevision() snapshots the current state of one or more movies into the
+// movie_revisions table. It must be called within the same transaction as the change
+// being recorded, after the change has been made, so that the snapshot and version
+// match the new state of the movie.
+func recordMovieRevision(ctx context.Context, tx DBTX, userID int64, action string, movieIDs ...int64) error {
+	query := `
+        INSERT INTO movie_revisions (movie_id, version, user_id, action, snapshot)
+        SELECT id, version, $2, $3, jsonb_build_object(
+            'title', title,
+            'year', year,
+            'runtime', runtime,
+            'genres', ` + movieGenresColumn + `)
+        FROM movies
+        WHERE id = ANY($1)`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(movieIDs), userID, action)
+	return err
+}
+
+// scanSnapshot() decodes the JSON snapshot of a movie revision. The runtime is stored
+// as a plain number, rather than in the "<runtime> mins" format used by the API, so it
+// is decoded into an intermediate struct first.
+func scanSnapshot(data []byte, snapshot *MovieSnapshot) error {
+	var raw struct {
+		Title   string   `json:"title"`
+		Year    int32    `json:"year"`
+		Runtime int32    `json:"runtime"`
+		Genres  []string `json:"genres"`
+	}
+
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	snapshot.Title = raw.Title
+	snapshot.Year = raw.Year
+	snapshot.Runtime = Runtime(raw.Runtime)
+	snapshot.Genres = raw.Genres
+	return nil
+}
+
+// GetRevisions() returns a page of the revision history of a movie.
+func (m MovieModel) GetRevisions(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, movie_id, version, user_id, action, snapshot
+        FROM movie_revisions
+        WHERE movie_id = $1
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+		var snapshot []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.ID,
+			&revision.CreatedAt,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.UserID,
+			&revision.Action,
+			&snapshot,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = scanSnapshot(snapshot, &revision.Snapshot)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// GetRevision() returns the revision of a movie with a specific version number.
+func (m MovieModel) GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, created_at, movie_id, version, user_id, action, snapshot
+        FROM movie_revisions
+        WHERE movie_id = $1 AND version = $2`
+
+	var revision MovieRevision
+	var snapshot []byte
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.ID,
+		&revision.CreatedAt,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.UserID,
+		&revision.Action,
+		&snapshot,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = scanSnapshot(snapshot, &revision.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
@@ -45,26 +45,37 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 		return ErrInvalidRuntimeFormat
 	}
 
+	// Parse the unquoted string, and assign the result to the receiver. Note that we
+	// use the * operator to deference the receiver (which is a pointer to a Runtime
+	// type) in order to set the underlying value of the pointer
+	runtime, err := ParseRuntime(unquotedJSONValue)
+	if err != nil {
+		return err
+	}
+
+	*r = runtime
+
+	return nil
+}
+
+// ParseRuntime() parses a runtime in the "<runtime> mins" format, for sources other
+// than JSON such as CSV imports.
+func ParseRuntime(value string) (Runtime, error) {
 	// Split the string to isolate the part containing the number.
-	parts := strings.Split(unquotedJSONValue, " ")
+	parts := strings.Split(value, " ")
 
 	// Sanity check the parts of the string to make sure it was in the expected format.
 	// If it isn't, we return the ErrInvalidRuntimeFormat error again.
 	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+		return 0, ErrInvalidRuntimeFormat
 	}
 
 	// Otherwise, parse the string containing the number into an int32. Again, if this
 	// fails return the ErrInvalidRuntimeFormat error.
 	i, err := strconv.ParseInt(parts[0], 10, 32)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return 0, ErrInvalidRuntimeFormat
 	}
 
-	// Convert the int32 to a Runtime type and assign this to the receiver. Note that we
-	// use the * operator to deference the receiver (which is a pointer to a Runtime
-	// type) in order to set the underlying value of the pointer
-	*r = Runtime(i)
-
-	return nil
+	return Runtime(i), nil
 }
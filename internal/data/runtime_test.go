@@ -0,0 +1,102 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRuntimeMarshalJSON(t *testing.T) {
+	tests := []struct {
+		runtime Runtime
+		want    string
+	}{
+		{runtime: 107, want: `"107 mins"`},
+		{runtime: 1, want: `"1 mins"`},
+		{runtime: 0, want: `"0 mins"`},
+	}
+
+	for _, tt := range tests {
+		js, err := json.Marshal(tt.runtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(js) != tt.want {
+			t.Errorf("json.Marshal(%d) = %s; want %s", tt.runtime, js, tt.want)
+		}
+	}
+
+	// The runtime is encoded in the same way inside a movie.
+	js, err := json.Marshal(struct {
+		Runtime Runtime `json:"runtime,omitempty"`
+	}{Runtime: 102})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != `{"runtime":"102 mins"}` {
+		t.Errorf("got %s; want %s", js, `{"runtime":"102 mins"}`)
+	}
+}
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		js      string
+		want    Runtime
+		wantErr error
+	}{
+		{js: `"107 mins"`, want: 107},
+		{js: `"0 mins"`, want: 0},
+		{js: `"-5 mins"`, want: -5},
+		{js: `107`, wantErr: ErrInvalidRuntimeFormat},
+		{js: `"107"`, wantErr: ErrInvalidRuntimeFormat},
+		{js: `"107 minutes"`, wantErr: ErrInvalidRuntimeFormat},
+		{js: `"107  mins"`, wantErr: ErrInvalidRuntimeFormat},
+		{js: `"one mins"`, wantErr: ErrInvalidRuntimeFormat},
+		{js: `"99999999999 mins"`, wantErr: ErrInvalidRuntimeFormat},
+		{js: `null`, wantErr: ErrInvalidRuntimeFormat},
+	}
+
+	for _, tt := range tests {
+		var runtime Runtime
+
+		err := runtime.UnmarshalJSON([]byte(tt.js))
+
+		switch {
+		case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+			t.Errorf("UnmarshalJSON(%s) error = %v; want %v", tt.js, err, tt.wantErr)
+		case tt.wantErr == nil && err != nil:
+			t.Errorf("UnmarshalJSON(%s) error = %v; want none", tt.js, err)
+		case tt.wantErr == nil && runtime != tt.want:
+			t.Errorf("UnmarshalJSON(%s) = %d; want %d", tt.js, runtime, tt.want)
+		}
+	}
+}
+
+func TestParseRuntime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Runtime
+		wantErr bool
+	}{
+		{value: "107 mins", want: 107},
+		{value: "2147483647 mins", want: 2147483647},
+		{value: "2147483648 mins", wantErr: true},
+		{value: "107mins", wantErr: true},
+		{value: "107 MINS", wantErr: true},
+		{value: " 107 mins", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRuntime(tt.value)
+
+		switch {
+		case tt.wantErr && !errors.Is(err, ErrInvalidRuntimeFormat):
+			t.Errorf("ParseRuntime(%q) error = %v; want %v", tt.value, err, ErrInvalidRuntimeFormat)
+		case !tt.wantErr && err != nil:
+			t.Errorf("ParseRuntime(%q) error = %v; want none", tt.value, err)
+		case !tt.wantErr && got != tt.want:
+			t.Errorf("ParseRuntime(%q) = %d; want %d", tt.value, got, tt.want)
+		}
+	}
+}
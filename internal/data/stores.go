@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// The store interfaces describe the methods that the handlers use on each model. The
+// PostgreSQL models in this package implement them, as do the in-memory stores in
+// the data/memory package, which lets the handlers be tested without a database.
+
+// MovieStore stores movies along with their revision history.
+type MovieStore interface {
+	GetAll(ctx context.Context, title string, genres []string, search string, personID int64, filters Filters) ([]*Movie, Metadata, error)
+	GetFacets(ctx context.Context, title string, genres []string, search string, personID int64, facets []string) (Facets, error)
+	Insert(ctx context.Context, movie *Movie, userID int64) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	Update(ctx context.Context, movie *Movie, userID int64) error
+	Delete(ctx context.Context, id int64, version int32, userID int64) error
+	GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error)
+	Restore(ctx context.Context, id, userID int64) error
+	PurgeTrash(ctx context.Context, retention time.Duration) (int64, error)
+	Suggest(ctx context.Context, prefix string, limit int) ([]*MovieSuggestion, error)
+	GetRevisions(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error)
+	GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error)
+	Import(ctx context.Context, movies []*Movie, userID int64) error
+	Export(ctx context.Context, title string, genres []string, search string, personID int64, fn func(movie *Movie) error) error
+}
+
+// GenreStore stores the vocabulary of genres. It is needed alongside MovieStore
+// because movies are validated against the vocabulary.
+type GenreStore interface {
+	GetAll(ctx context.Context) ([]*Genre, error)
+	GetNames(ctx context.Context) ([]string, error)
+	Get(ctx context.Context, id int64) (*Genre, error)
+	Insert(ctx context.Context, genre *Genre) error
+	Update(ctx context.Context, genre *Genre) error
+	Delete(ctx context.Context, id int64) error
+}
+
+// UserStore stores user accounts.
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+}
+
+// TokenStore stores the tokens for each scope, such as activation and authentication.
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteByHash(ctx context.Context, hash []byte) error
+	GetSessions(ctx context.Context, userID int64) ([]*Session, error)
+	DeleteSession(ctx context.Context, userID, id int64) error
+	UpdateLastUsed(ctx context.Context, hash []byte, lastUsedAt time.Time) error
+	GetByHash(ctx context.Context, scope string, hash []byte) (*Token, error)
+	MarkRotated(ctx context.Context, hash []byte) error
+	DeleteFamily(ctx context.Context, userID int64, family string) error
+	DeleteAllForFamily(ctx context.Context, scope string, userID int64, family string) error
+	DeleteExpired(ctx context.Context, rotatedRetention time.Duration) (int64, error)
+}
+
+// PermissionStore stores the permission codes granted to each user.
+type PermissionStore interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+// PersonStore stores people and the credits which link them to movies.
+type PersonStore interface {
+	GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error)
+	Get(ctx context.Context, id int64) (*Person, error)
+	Insert(ctx context.Context, person *Person) error
+	Update(ctx context.Context, person *Person) error
+	Delete(ctx context.Context, id int64) error
+	GetCredits(ctx context.Context, movieID int64) ([]*Credit, error)
+	SetCredits(ctx context.Context, movieID int64, credits []*Credit) error
+}
+
+// ReviewStore stores the reviews of movies, keeping each movie's rating in step with
+// them.
+type ReviewStore interface {
+	GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error)
+	Get(ctx context.Context, movieID, id int64) (*Review, error)
+	Insert(ctx context.Context, review *Review) error
+	Update(ctx context.Context, review *Review) error
+	Delete(ctx context.Context, movieID, id int64) error
+}
+
+// WatchlistStore stores the movies that each user has saved to their watchlist.
+type WatchlistStore interface {
+	GetAllForUser(ctx context.Context, userID int64, watched *bool, filters Filters) ([]*WatchlistEntry, Metadata, error)
+	Get(ctx context.Context, userID, movieID int64) (*WatchlistEntry, error)
+	Put(ctx context.Context, userID int64, entry *WatchlistEntry) (bool, error)
+	Delete(ctx context.Context, userID, movieID int64) error
+}
+
+// Check at compile time that the PostgreSQL models implement the interfaces.
+var (
+	_ MovieStore      = MovieModel{}
+	_ GenreStore      = GenreModel{}
+	_ UserStore       = UserModel{}
+	_ TokenStore      = TokenModel{}
+	_ PermissionStore = PermissionModel{}
+	_ PersonStore     = PersonModel{}
+	_ ReviewStore     = ReviewModel{}
+	_ WatchlistStore  = WatchlistModel{}
+)
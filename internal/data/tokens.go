@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"log"
 	"time"
 
@@ -17,6 +18,8 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
 )
 
 // Define a Token struct to hold the data for an individual token. This includes the
@@ -28,9 +31,39 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+
+	// The session details. The IP address and User-Agent of the client which the token
+	// was issued to are set before inserting the token, and the rest by Insert().
+	ID         int64     `json:"-"`
+	CreatedAt  time.Time `json:"-"`
+	LastUsedAt time.Time `json:"-"`
+	IP         string    `json:"-"`
+	UserAgent  string    `json:"-"`
+
+	// Family groups the access and refresh tokens issued by a login and the refreshes
+	// that follow it. It is empty for tokens which aren't part of a login, such as
+	// activation tokens. Rotated is set on refresh tokens which have been used.
+	Family  string `json:"-"`
+	Rotated bool   `json:"-"`
+}
+
+// Session describes an authentication token, for users to see where their account is
+// logged in. The ID identifies the session without revealing the token, and Current
+// marks the session that the request listing the sessions was made with.
+type Session struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Expiry     time.Time `json:"expiry"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+	Hash       []byte    `json:"-"`
 }
 
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+// GenerateToken() creates a token with a random plaintext for the user, which expires
+// after ttl. It doesn't save the token, which is left to the token store.
+func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Create a Token instance containig the user ID, expiry, and scope information.
 	// Notice that we did add the provided ttl (time-to-live) duration paramter to the
 	// current time to get the expiry time?
@@ -72,6 +105,18 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// NewTokenFamily() returns a random identifier for a new family of tokens.
+func NewTokenFamily() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
 // Check that the plaintext token has bn provided and is exactly 26 bytes long
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
@@ -80,42 +125,271 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 
 // Define the TokenModel type.
 type TokenModel struct {
-	DB *sql.DB
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 // The New()m method is a shortcut which creates a new Token struct and then inserts the
 // data in the tokens table.
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := GenerateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
-// Insert() adds the data for a specific token to the tokens table.
-func (m TokenModel) Insert(token *Token) error {
+// Insert() adds the data for a specific token to the tokens table, and sets the
+// system-generated session ID and timestamps on the token.
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-    INSERT INTO tokens (hash, user_id, expiry, scope)
-    VALUES ($1, $2, $3, $4)
+    INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family)
+    VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+    RETURNING id, created_at, last_used_at
     `
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.Family}
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt, &token.LastUsedAt)
 }
 
 // DeleteAllForUser() deletes all token for a specific user and scope
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	log.Println("DeleteAllForUser")
 	query := `
         DELETE FROM tokens
         WHERE scope = $1 AND user_id = $2
     `
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteByHash() deletes the token with the given hash, if it exists, along with the
+// rest of its family. Logging out with an access token then also revokes the refresh
+// token which could be used to get a new one. Deleting a token which has already been
+// deleted isn't an error, so that logging out twice works.
+func (m TokenModel) DeleteByHash(ctx context.Context, hash []byte) error {
+	query := `
+        DELETE FROM tokens
+        WHERE hash = $1
+        OR family = (SELECT family FROM tokens WHERE hash = $1)
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hash)
+	return err
+}
+
+// GetSessions() returns the user's authentication tokens which haven't expired, most
+// recently used first. Each login is one session however many times it has been
+// refreshed, so only the latest token in each family is included.
+func (m TokenModel) GetSessions(ctx context.Context, userID int64) ([]*Session, error) {
+	query := `
+        SELECT id, created_at, last_used_at, expiry, ip, user_agent, hash
+        FROM (
+            SELECT DISTINCT ON (COALESCE(family, id::text))
+                id, created_at, last_used_at, expiry, ip, user_agent, hash
+            FROM tokens
+            WHERE user_id = $1 AND scope = $2 AND expiry > $3
+            ORDER BY COALESCE(family, id::text), id DESC
+        ) AS sessions
+        ORDER BY last_used_at DESC, id DESC
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+			&session.Hash,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession() deletes one of the user's authentication tokens by its session ID,
+// along with the rest of its family. It returns ErrRecordNotFound if the user has no
+// session with the ID, so that users can't find out about other users' sessions.
+func (m TokenModel) DeleteSession(ctx context.Context, userID, id int64) error {
+	query := `
+        DELETE FROM tokens
+        WHERE user_id = $2
+        AND (
+            (id = $1 AND scope = $3)
+            OR family = (SELECT family FROM tokens WHERE id = $1 AND user_id = $2 AND scope = $3)
+        )
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdateLastUsed() records that the token with the given hash was used at lastUsedAt.
+// The time never goes backwards, so an update which arrives late is ignored.
+func (m TokenModel) UpdateLastUsed(ctx context.Context, hash []byte, lastUsedAt time.Time) error {
+	query := `
+        UPDATE tokens
+        SET last_used_at = $2
+        WHERE hash = $1 AND last_used_at < $2
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hash, lastUsedAt)
+	return err
+}
+
+// GetByHash() returns the token with the given hash and scope, if it hasn't expired.
+func (m TokenModel) GetByHash(ctx context.Context, scope string, hash []byte) (*Token, error) {
+	query := `
+        SELECT hash, user_id, expiry, scope, id, created_at, last_used_at, ip, user_agent, COALESCE(family, ''), rotated_at IS NOT NULL
+        FROM tokens
+        WHERE hash = $1 AND scope = $2 AND expiry > $3
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var token Token
+
+	err := m.DB.QueryRowContext(ctx, query, hash, scope, time.Now()).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.ID,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+		&token.IP,
+		&token.UserAgent,
+		&token.Family,
+		&token.Rotated,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// MarkRotated() marks the refresh token with the given hash as used. It returns
+// ErrEditConflict if the token has already been rotated, which can happen when two
+// requests use the same refresh token at once.
+func (m TokenModel) MarkRotated(ctx context.Context, hash []byte) error {
+	query := `
+        UPDATE tokens
+        SET rotated_at = NOW()
+        WHERE hash = $1 AND rotated_at IS NULL
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// DeleteFamily() deletes all of the user's tokens in the family.
+func (m TokenModel) DeleteFamily(ctx context.Context, userID int64, family string) error {
+	query := `
+        DELETE FROM tokens
+        WHERE user_id = $1 AND family = $2
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, family)
+	return err
+}
+
+// DeleteAllForFamily() deletes the user's tokens in the family with the given scope.
+func (m TokenModel) DeleteAllForFamily(ctx context.Context, scope string, userID int64, family string) error {
+	query := `
+        DELETE FROM tokens
+        WHERE scope = $1 AND user_id = $2 AND family = $3
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, userID, family)
+	return err
+}
+
+// DeleteExpired() deletes every token which has expired, along with the refresh tokens
+// which were rotated more than rotatedRetention ago, returning the number of tokens
+// deleted. Rotated refresh tokens are only kept to detect their reuse, so after the
+// retention period a reused token is treated as unknown rather than revoking its
+// family.
+func (m TokenModel) DeleteExpired(ctx context.Context, rotatedRetention time.Duration) (int64, error) {
+	query := `
+        DELETE FROM tokens
+        WHERE expiry < $1 OR rotated_at < $2
+    `
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	result, err := m.DB.ExecContext(ctx, query, now, now.Add(-rotatedRetention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
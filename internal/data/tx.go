@@ -0,0 +1,56 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// DBTX is the set of methods shared by *sql.DB and *sql.Tx that the models use to run
+// their queries. It means that the same model can run queries directly against the
+// connection pool, or within a transaction started by Models.WithTx().
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// txn is a transaction started by beginTx(). When the model is already bound to a
+// transaction by Models.WithTx(), the txn joins that transaction instead of starting a
+// new one, and its Commit() and Rollback() methods do nothing so that the outer
+// transaction stays in control.
+type txn struct {
+	*sql.Tx
+	joined bool
+}
+
+func (t txn) Commit() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t txn) Rollback() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
+
+// beginTx() starts a transaction for a model method which makes several changes, or
+// joins the existing transaction if the model is bound to one. Note that when an
+// operation fails within a joined transaction its earlier changes aren't undone until
+// the outer transaction is rolled back, which WithTx() does when fn returns an error.
+func beginTx(ctx context.Context, db DBTX, opts *sql.TxOptions) (txn, error) {
+	switch db := db.(type) {
+	case *sql.DB:
+		tx, err := db.BeginTx(ctx, opts)
+		return txn{Tx: tx}, err
+	case *sql.Tx:
+		return txn{Tx: db, joined: true}, nil
+	default:
+		return txn{}, fmt.Errorf("cannot begin a transaction on %T", db)
+	}
+}
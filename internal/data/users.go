@@ -112,14 +112,15 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 // Create a UserModel struct which wraps the connection pool
 type UserModel struct {
-	DB *sql.DB
+	DB           DBTX
+	QueryTimeout time.Duration
 }
 
 // Insert a new record in the database for the user. Note that the id, created_at, and
 // version fields are all automatically generated by our database, so we use the
 // RETURNING clause to read them into the user struct after the insert, in the same way
 // that we did when creatinga movie.
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
     INSERT INTO users(name, email, password_hash, activated)
     VALUES($1, $2, $3, $4)
@@ -128,7 +129,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	// If the table already contains a record with this email address, then we try
@@ -150,7 +151,7 @@ func (m UserModel) Insert(user *User) error {
 // Retrieve the USer details from the database based on the user's email address.
 // Becaues we have a UNIQUE constraint on the email column, this SQL qery will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
     SELECT id, created_at, name, email, password_hash, activated, version
     FROM users
@@ -158,7 +159,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
     `
 
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -188,7 +189,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 // when updating a movie. And we also check for aviolation of the "users_email_key"
 // constraint when performing the update, just like we did when inserting the user
 // record originally.
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	log.Println("update user")
 	query := `
     UPDATE users
@@ -205,7 +206,7 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -223,7 +224,7 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	log.Println("GetForToken()")
 	// Calculate the SHA-256 of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
@@ -247,7 +248,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct. If no matching
@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WatchlistEntry is a movie saved to a user's watchlist. WatchedAt is set when the
+// movie is first marked as watched, and cleared if it is marked as unwatched again.
+type WatchlistEntry struct {
+	MovieID   int64      `json:"movie_id"`
+	Title     string     `json:"title"`
+	AddedAt   time.Time  `json:"added_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Watched   bool       `json:"watched"`
+	WatchedAt *time.Time `json:"watched_at,omitempty"`
+	Favourite bool       `json:"favourite"`
+}
+
+// WatchlistModel manages the movies that users have saved. Entries are removed
+// automatically when either the user or the movie is deleted.
+type WatchlistModel struct {
+	DB           DBTX
+	QueryTimeout time.Duration
+}
+
+// GetAllForUser() returns a page of the movies on a user's watchlist. If watched is
+// not nil, only entries with that watched state are returned.
+func (m WatchlistModel) GetAllForUser(ctx context.Context, userID int64, watched *bool, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), watchlist.movie_id, movies.title, watchlist.added_at,
+            watchlist.updated_at, watchlist.watched, watchlist.watched_at, watchlist.favourite
+        FROM watchlist
+        INNER JOIN movies ON movies.id = watchlist.movie_id
+        WHERE watchlist.user_id = $1
+        AND movies.deleted_at IS NULL
+        AND (watchlist.watched = $2 OR $2 IS NULL)
+        ORDER BY %s %s, watchlist.movie_id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, watched, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		var entry WatchlistEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.MovieID,
+			&entry.Title,
+			&entry.AddedAt,
+			&entry.UpdatedAt,
+			&entry.Watched,
+			&entry.WatchedAt,
+			&entry.Favourite,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+func (m WatchlistModel) Get(ctx context.Context, userID, movieID int64) (*WatchlistEntry, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT watchlist.movie_id, movies.title, watchlist.added_at, watchlist.updated_at,
+            watchlist.watched, watchlist.watched_at, watchlist.favourite
+        FROM watchlist
+        INNER JOIN movies ON movies.id = watchlist.movie_id
+        WHERE watchlist.user_id = $1 AND watchlist.movie_id = $2
+        AND movies.deleted_at IS NULL`
+
+	var entry WatchlistEntry
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(
+		&entry.MovieID,
+		&entry.Title,
+		&entry.AddedAt,
+		&entry.UpdatedAt,
+		&entry.Watched,
+		&entry.WatchedAt,
+		&entry.Favourite,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// Put() adds a movie to a user's watchlist, or updates the watched and favourite state
+// if it is already there. It reports whether a new entry was created. If the movie
+// doesn't exist or is in the trash ErrRecordNotFound is returned.
+func (m WatchlistModel) Put(ctx context.Context, userID int64, entry *WatchlistEntry) (bool, error) {
+	// The (xmax = 0) trick tells us whether the row was inserted rather than updated,
+	// because xmax is only set on the new row version when ON CONFLICT updates it. The
+	// row is selected from movies so that nothing is inserted for a trashed movie.
+	query := `
+        WITH upserted AS (
+            INSERT INTO watchlist (user_id, movie_id, watched, watched_at, favourite)
+            SELECT $1::bigint, id, $3::bool, CASE WHEN $3::bool THEN NOW() END, $4::bool
+            FROM movies
+            WHERE id = $2 AND deleted_at IS NULL
+            ON CONFLICT (user_id, movie_id) DO UPDATE
+            SET watched = EXCLUDED.watched,
+                watched_at = CASE WHEN EXCLUDED.watched THEN COALESCE(watchlist.watched_at, NOW()) END,
+                favourite = EXCLUDED.favourite,
+                updated_at = NOW()
+            RETURNING movie_id, added_at, updated_at, watched_at, (xmax = 0) AS inserted
+        )
+        SELECT movies.title, upserted.added_at, upserted.updated_at, upserted.watched_at, upserted.inserted
+        FROM upserted
+        INNER JOIN movies ON movies.id = upserted.movie_id`
+
+	args := []any{userID, entry.MovieID, entry.Watched, entry.Favourite}
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var inserted bool
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&entry.Title,
+		&entry.AddedAt,
+		&entry.UpdatedAt,
+		&entry.WatchedAt,
+		&inserted,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	return inserted, nil
+}
+
+// Delete() removes a movie from a user's watchlist.
+func (m WatchlistModel) Delete(ctx context.Context, userID, movieID int64) error {
+	if movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM watchlist
+        WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := withQueryTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Package jsonpatch applies RFC 7396 JSON Merge Patch and RFC 6902 JSON Patch
+// documents to JSON values that have been decoded into the generic types used by
+// encoding/json: map[string]any, []any, string, float64, bool and nil.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrTestFailed is returned by Apply() when a "test" operation doesn't match the
+// document. The whole patch is rejected when this happens.
+var ErrTestFailed = errors.New("test operation failed")
+
+// Operation is a single operation in a JSON Patch document. Value is kept as raw JSON
+// so that an explicit null can be told apart from a missing value.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Merge() applies a JSON Merge Patch to the target and returns the result. Members of
+// the patch which are null are removed from the target, objects are merged
+// recursively, and any other value replaces the target value outright. Note that the
+// target is modified in place.
+func Merge(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = Merge(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// Apply() applies the operations of a JSON Patch to the document in order, and returns
+// the result. The add, remove, replace and test operations are supported. If any
+// operation fails an error is returned and the patch should be discarded, as the
+// document may have been partially modified.
+func Apply(doc any, operations []Operation) (any, error) {
+	for i, operation := range operations {
+		tokens, err := parsePointer(operation.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+
+		switch operation.Op {
+		case "add", "replace", "test":
+			if operation.Value == nil {
+				return nil, fmt.Errorf("operation %d: value must be provided", i)
+			}
+
+			var value any
+			err = json.Unmarshal(operation.Value, &value)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: value must be valid JSON", i)
+			}
+
+			switch {
+			case operation.Op != "test" && len(tokens) == 0:
+				// Adding or replacing the root replaces the whole document.
+				doc = value
+			case operation.Op == "add":
+				doc, err = update(doc, tokens, func(container any, token string) (any, error) {
+					return add(container, token, value)
+				})
+			case operation.Op == "replace":
+				doc, err = update(doc, tokens, func(container any, token string) (any, error) {
+					return replace(container, token, value)
+				})
+			default:
+				var current any
+				current, err = get(doc, tokens)
+				if err == nil && !reflect.DeepEqual(current, value) {
+					return nil, fmt.Errorf("operation %d: %w at path %q", i, ErrTestFailed, operation.Path)
+				}
+			}
+
+		case "remove":
+			if len(tokens) == 0 {
+				return nil, fmt.Errorf("operation %d: the whole document cannot be removed", i)
+			}
+			doc, err = update(doc, tokens, remove)
+
+		default:
+			return nil, fmt.Errorf("operation %d: unsupported op %q", i, operation.Op)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// parsePointer() splits an RFC 6901 JSON Pointer into its reference tokens, undoing the
+// ~1 and ~0 escapes. The empty pointer refers to the whole document and has no tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must be empty or start with a slash", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// get() returns the value which the tokens refer to.
+func get(doc any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch container := doc.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path member %q does not exist", token)
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("path member %q does not exist", token)
+		}
+	}
+
+	return doc, nil
+}
+
+// update() walks down the document to the container which holds the last token, calls
+// fn to change it, and then sets the new container in its parent. The containers are
+// replaced all the way back up to the root, because inserting into or removing from an
+// array returns a new slice. There must be at least one token.
+func update(doc any, tokens []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+
+	child, err := get(doc, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = update(child, tokens[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return replace(doc, tokens[0], child)
+}
+
+// add() adds a value to an object member, or inserts it into an array at the given
+// index. The "-" index appends to the end of the array.
+func add(container any, token string, value any) (any, error) {
+	switch container := container.(type) {
+	case map[string]any:
+		container[token] = value
+		return container, nil
+	case []any:
+		i := len(container)
+		if token != "-" {
+			var err error
+			i, err = arrayIndex(token, len(container))
+			if err != nil {
+				return nil, err
+			}
+		}
+		container = append(container, nil)
+		copy(container[i+1:], container[i:])
+		container[i] = value
+		return container, nil
+	default:
+		return nil, fmt.Errorf("path member %q cannot be added to a %s", token, kind(container))
+	}
+}
+
+// replace() sets the value of an existing object member or array element.
+func replace(container any, token string, value any) (any, error) {
+	switch container := container.(type) {
+	case map[string]any:
+		if _, ok := container[token]; !ok {
+			return nil, fmt.Errorf("path member %q does not exist", token)
+		}
+		container[token] = value
+		return container, nil
+	case []any:
+		i, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		container[i] = value
+		return container, nil
+	default:
+		return nil, fmt.Errorf("path member %q does not exist", token)
+	}
+}
+
+// remove() deletes an existing object member or array element.
+func remove(container any, token string) (any, error) {
+	switch container := container.(type) {
+	case map[string]any:
+		if _, ok := container[token]; !ok {
+			return nil, fmt.Errorf("path member %q does not exist", token)
+		}
+		delete(container, token)
+		return container, nil
+	case []any:
+		i, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		return append(container[:i], container[i+1:]...), nil
+	default:
+		return nil, fmt.Errorf("path member %q does not exist", token)
+	}
+}
+
+// arrayIndex() parses an array index token, which must be a non-negative integer
+// without leading zeros that is no greater than max.
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("path member %q is not a valid array index", token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i > max {
+		return 0, fmt.Errorf("array index %s is out of bounds", token)
+	}
+
+	return i, nil
+}
+
+// kind() describes the JSON type of a value for error messages.
+func kind(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return "value"
+	}
+}
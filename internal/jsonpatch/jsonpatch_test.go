@@ -0,0 +1,363 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decode() unmarshals a JSON test fixture into the generic types that the package
+// works with.
+func decode(t *testing.T, js string) any {
+	t.Helper()
+
+	var v any
+	err := json.Unmarshal([]byte(js), &v)
+	if err != nil {
+		t.Fatalf("decoding %s: %s", js, err)
+	}
+	return v
+}
+
+func TestApply(t *testing.T) {
+	const movie = `{"title":"Moana","year":2016,"genres":["animation","adventure"],"a/b":1,"m~n":2}`
+
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "add at index",
+			doc:   movie,
+			patch: `[{"op":"add","path":"/genres/1","value":"family"}]`,
+			want:  `{"title":"Moana","year":2016,"genres":["animation","family","adventure"],"a/b":1,"m~n":2}`,
+		},
+		{
+			name:  "add at start",
+			doc:   `{"genres":["animation"]}`,
+			patch: `[{"op":"add","path":"/genres/0","value":"family"}]`,
+			want:  `{"genres":["family","animation"]}`,
+		},
+		{
+			name:  "add at end index",
+			doc:   `{"genres":["animation"]}`,
+			patch: `[{"op":"add","path":"/genres/1","value":"family"}]`,
+			want:  `{"genres":["animation","family"]}`,
+		},
+		{
+			name:  "add with dash appends",
+			doc:   `{"genres":["animation","adventure"]}`,
+			patch: `[{"op":"add","path":"/genres/-","value":"family"}]`,
+			want:  `{"genres":["animation","adventure","family"]}`,
+		},
+		{
+			name:  "add object member",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"add","path":"/runtime","value":"107 mins"}]`,
+			want:  `{"title":"Moana","runtime":"107 mins"}`,
+		},
+		{
+			name:  "add replaces existing member",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"add","path":"/title","value":"Up"}]`,
+			want:  `{"title":"Up"}`,
+		},
+		{
+			name:  "add null value",
+			doc:   `{"title":"Moana"}`,
+			patch: `[{"op":"add","path":"/year","value":null}]`,
+			want:  `{"title":"Moana","year":null}`,
+		},
+		{
+			name:    "add index out of bounds",
+			doc:     `{"genres":["animation"]}`,
+			patch:   `[{"op":"add","path":"/genres/2","value":"family"}]`,
+			wantErr: "array index 2 is out of bounds",
+		},
+		{
+			name:    "add index with leading zero",
+			doc:     `{"genres":["animation","adventure"]}`,
+			patch:   `[{"op":"add","path":"/genres/01","value":"family"}]`,
+			wantErr: `path member "01" is not a valid array index`,
+		},
+		{
+			name:    "add negative index",
+			doc:     `{"genres":["animation"]}`,
+			patch:   `[{"op":"add","path":"/genres/-1","value":"family"}]`,
+			wantErr: `path member "-1" is not a valid array index`,
+		},
+		{
+			name:    "add to missing parent",
+			doc:     `{"title":"Moana"}`,
+			patch:   `[{"op":"add","path":"/cast/0","value":"Auli'i Cravalho"}]`,
+			wantErr: `path member "cast" does not exist`,
+		},
+		{
+			name:    "add to scalar",
+			doc:     `{"title":"Moana"}`,
+			patch:   `[{"op":"add","path":"/title/x","value":1}]`,
+			wantErr: `path member "x" cannot be added to a string`,
+		},
+		{
+			name:    "add without value",
+			doc:     `{"title":"Moana"}`,
+			patch:   `[{"op":"add","path":"/year"}]`,
+			wantErr: "value must be provided",
+		},
+		{
+			name:  "remove array element",
+			doc:   `{"genres":["animation","family","adventure"]}`,
+			patch: `[{"op":"remove","path":"/genres/1"}]`,
+			want:  `{"genres":["animation","adventure"]}`,
+		},
+		{
+			name:  "remove last array element",
+			doc:   `{"genres":["animation","family"]}`,
+			patch: `[{"op":"remove","path":"/genres/1"}]`,
+			want:  `{"genres":["animation"]}`,
+		},
+		{
+			name:    "remove array element out of bounds",
+			doc:     `{"genres":["animation"]}`,
+			patch:   `[{"op":"remove","path":"/genres/1"}]`,
+			wantErr: "array index 1 is out of bounds",
+		},
+		{
+			name:    "remove with dash",
+			doc:     `{"genres":["animation"]}`,
+			patch:   `[{"op":"remove","path":"/genres/-"}]`,
+			wantErr: `path member "-" is not a valid array index`,
+		},
+		{
+			name:  "remove object member",
+			doc:   `{"title":"Moana","year":2016}`,
+			patch: `[{"op":"remove","path":"/year"}]`,
+			want:  `{"title":"Moana"}`,
+		},
+		{
+			name:    "remove missing member",
+			doc:     `{"title":"Moana"}`,
+			patch:   `[{"op":"remove","path":"/year"}]`,
+			wantErr: `path member "year" does not exist`,
+		},
+		{
+			name:    "remove whole document",
+			doc:     `{"title":"Moana"}`,
+			patch:   `[{"op":"remove","path":""}]`,
+			wantErr: "the whole document cannot be removed",
+		},
+		{
+			name:  "replace array element",
+			doc:   `{"genres":["animation","adventure"]}`,
+			patch: `[{"op":"replace","path":"/genres/1","value":"family"}]`,
+			want:  `{"genres":["animation","family"]}`,
+		},
+		{
+			name:    "replace missing member",
+			doc:     `{"title":"Moana"}`,
+			patch:   `[{"op":"replace","path":"/year","value":2016}]`,
+			wantErr: `path member "year" does not exist`,
+		},
+		{
+			name:    "replace with dash",
+			doc:     `{"genres":["animation"]}`,
+			patch:   `[{"op":"replace","path":"/genres/-","value":"family"}]`,
+			wantErr: `path member "-" is not a valid array index`,
+		},
+		{
+			name:  "replace whole document",
+			doc:   movie,
+			patch: `[{"op":"replace","path":"","value":{"title":"Up"}}]`,
+			want:  `{"title":"Up"}`,
+		},
+		{
+			name:  "add whole document",
+			doc:   movie,
+			patch: `[{"op":"add","path":"","value":["Up"]}]`,
+			want:  `["Up"]`,
+		},
+		{
+			name:  "escaped slash",
+			doc:   movie,
+			patch: `[{"op":"replace","path":"/a~1b","value":3}]`,
+			want:  `{"title":"Moana","year":2016,"genres":["animation","adventure"],"a/b":3,"m~n":2}`,
+		},
+		{
+			name:  "escaped tilde",
+			doc:   movie,
+			patch: `[{"op":"remove","path":"/m~0n"}]`,
+			want:  `{"title":"Moana","year":2016,"genres":["animation","adventure"],"a/b":1}`,
+		},
+		{
+			name:  "escapes are undone in order",
+			doc:   `{"~1":1}`,
+			patch: `[{"op":"replace","path":"/~01","value":2}]`,
+			want:  `{"~1":2}`,
+		},
+		{
+			name:  "test passes",
+			doc:   movie,
+			patch: `[{"op":"test","path":"/genres","value":["animation","adventure"]},{"op":"replace","path":"/year","value":2017}]`,
+			want:  `{"title":"Moana","year":2017,"genres":["animation","adventure"],"a/b":1,"m~n":2}`,
+		},
+		{
+			name:  "test number",
+			doc:   movie,
+			patch: `[{"op":"test","path":"/year","value":2016.0}]`,
+			want:  movie,
+		},
+		{
+			name:    "test fails",
+			doc:     movie,
+			patch:   `[{"op":"replace","path":"/year","value":2017},{"op":"test","path":"/title","value":"Up"}]`,
+			wantErr: ErrTestFailed.Error(),
+		},
+		{
+			name:    "test missing member",
+			doc:     movie,
+			patch:   `[{"op":"test","path":"/runtime","value":"107 mins"}]`,
+			wantErr: `path member "runtime" does not exist`,
+		},
+		{
+			name:    "invalid pointer",
+			doc:     movie,
+			patch:   `[{"op":"remove","path":"year"}]`,
+			wantErr: `path "year" must be empty or start with a slash`,
+		},
+		{
+			name:    "unsupported op",
+			doc:     movie,
+			patch:   `[{"op":"move","from":"/year","path":"/released"}]`,
+			wantErr: `unsupported op "move"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var operations []Operation
+			err := json.Unmarshal([]byte(tt.patch), &operations)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Apply(decode(t, tt.doc), operations)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestApplyTestFailed checks that a failed test operation can be told apart from other
+// errors, as the handler responds to it with 409 Conflict rather than 422.
+func TestApplyTestFailed(t *testing.T) {
+	operations := []Operation{{Op: "test", Path: "/title", Value: json.RawMessage(`"Up"`)}}
+
+	_, err := Apply(decode(t, `{"title":"Moana"}`), operations)
+	if !errors.Is(err, ErrTestFailed) {
+		t.Errorf("got error %v; want %v", err, ErrTestFailed)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{
+			name:   "replace member",
+			target: `{"title":"Moana","year":2016}`,
+			patch:  `{"year":2017}`,
+			want:   `{"title":"Moana","year":2017}`,
+		},
+		{
+			name:   "add member",
+			target: `{"title":"Moana"}`,
+			patch:  `{"year":2016}`,
+			want:   `{"title":"Moana","year":2016}`,
+		},
+		{
+			name:   "null removes member",
+			target: `{"title":"Moana","year":2016}`,
+			patch:  `{"year":null}`,
+			want:   `{"title":"Moana"}`,
+		},
+		{
+			name:   "null for missing member",
+			target: `{"title":"Moana"}`,
+			patch:  `{"year":null}`,
+			want:   `{"title":"Moana"}`,
+		},
+		{
+			name:   "arrays are replaced",
+			target: `{"genres":["animation","adventure"]}`,
+			patch:  `{"genres":["family"]}`,
+			want:   `{"genres":["family"]}`,
+		},
+		{
+			name:   "nested objects are merged",
+			target: `{"title":"Moana","credits":{"director":"Ron Clements","writer":"Jared Bush"}}`,
+			patch:  `{"credits":{"director":"John Musker","writer":null}}`,
+			want:   `{"title":"Moana","credits":{"director":"John Musker"}}`,
+		},
+		{
+			name:   "nested object added",
+			target: `{"title":"Moana"}`,
+			patch:  `{"credits":{"director":"Ron Clements","writer":null}}`,
+			want:   `{"title":"Moana","credits":{"director":"Ron Clements"}}`,
+		},
+		{
+			name:   "object replaces scalar",
+			target: `{"credits":"none"}`,
+			patch:  `{"credits":{"director":"Ron Clements"}}`,
+			want:   `{"credits":{"director":"Ron Clements"}}`,
+		},
+		{
+			name:   "non-object patch replaces target",
+			target: `{"title":"Moana"}`,
+			patch:  `["Up"]`,
+			want:   `["Up"]`,
+		},
+		{
+			name:   "object patch replaces non-object target",
+			target: `["Up"]`,
+			patch:  `{"title":"Moana"}`,
+			want:   `{"title":"Moana"}`,
+		},
+		{
+			name:   "empty patch",
+			target: `{"title":"Moana"}`,
+			patch:  `{}`,
+			want:   `{"title":"Moana"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Merge(decode(t, tt.target), decode(t, tt.patch))
+
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
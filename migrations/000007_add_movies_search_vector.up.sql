@@ -0,0 +1,7 @@
+-- Keep a stemmed tsvector of the searchable movie text in a generated column, so that
+-- it never drifts out of sync with the row. Title words get the highest weight; any
+-- future descriptive columns should be appended here with a lower weight.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (setweight(to_tsvector('english', coalesce(title, '')), 'A')) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_search_vector_idx ON movies USING GIN (search_vector);
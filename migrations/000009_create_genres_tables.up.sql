@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Genre names are unique regardless of case, so "Sci-Fi" and "sci-fi" can't coexist.
+CREATE UNIQUE INDEX IF NOT EXISTS genres_name_lower_idx ON genres (lower(name));
+
+-- Genres which are still in use by a movie can't be deleted.
+CREATE TABLE IF NOT EXISTS movies_genres (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE RESTRICT,
+    position integer NOT NULL,
+    PRIMARY KEY (movie_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS movies_genres_genre_id_idx ON movies_genres (genre_id);
+
+-- Build the vocabulary from the existing genre arrays, keeping the first spelling (in
+-- alphabetical order) of any names which only differ by case.
+INSERT INTO genres (name)
+SELECT DISTINCT ON (lower(genre)) genre
+FROM movies, unnest(movies.genres) AS genre
+ORDER BY lower(genre), genre
+ON CONFLICT DO NOTHING;
+
+INSERT INTO movies_genres (movie_id, genre_id, position)
+SELECT movies.id, genres.id, min(u.position)
+FROM movies, unnest(movies.genres) WITH ORDINALITY AS u(name, position)
+INNER JOIN genres ON lower(genres.name) = lower(u.name)
+GROUP BY movies.id, genres.id
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS genres_length_check;
+DROP INDEX IF EXISTS movies_genres_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS genres;
+
+INSERT INTO permissions (code)
+VALUES
+    ('genres:write');
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS movie_credits (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL,
+    character text NOT NULL DEFAULT '',
+    billing_order integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (movie_id, person_id, role, character)
+);
+
+ALTER TABLE movie_credits ADD CONSTRAINT movie_credits_role_check CHECK (role IN ('director', 'writer', 'actor'));
+
+ALTER TABLE movie_credits ADD CONSTRAINT movie_credits_billing_order_check CHECK (billing_order >= 0);
+
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
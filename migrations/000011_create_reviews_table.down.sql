@@ -0,0 +1,3 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_sum;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_count;
+DROP TABLE IF EXISTS reviews;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    snapshot jsonb NOT NULL,
+    UNIQUE (movie_id, version)
+);
+
+ALTER TABLE movie_revisions ADD CONSTRAINT movie_revisions_action_check CHECK (action IN ('insert', 'update', 'delete', 'restore'));
+
+-- Record the current state of the existing movies as their first revision, so that every
+-- movie has a starting point to revert to. The acting user isn't known for these.
+INSERT INTO movie_revisions (movie_id, version, action, snapshot)
+SELECT movies.id, movies.version, 'insert', jsonb_build_object(
+    'title', movies.title,
+    'year', movies.year,
+    'runtime', movies.runtime,
+    'genres', ARRAY(
+        SELECT genres.name
+        FROM movies_genres
+        INNER JOIN genres ON genres.id = movies_genres.genre_id
+        WHERE movies_genres.movie_id = movies.id
+        ORDER BY movies_genres.position))
+FROM movies
+ON CONFLICT DO NOTHING;
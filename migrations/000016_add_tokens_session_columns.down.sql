@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS tokens_user_id_scope_idx;
+DROP INDEX IF EXISTS tokens_id_idx;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE tokens DROP COLUMN IF EXISTS ip;
+ALTER TABLE tokens DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS created_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS id;
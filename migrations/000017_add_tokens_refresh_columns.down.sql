@@ -0,0 +1,6 @@
+DELETE FROM tokens WHERE scope = 'refresh';
+
+DROP INDEX IF EXISTS tokens_family_idx;
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS family;
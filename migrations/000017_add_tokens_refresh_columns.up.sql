@@ -0,0 +1,7 @@
+-- A login issues an access token and a refresh token in a new family. Each refresh
+-- marks the refresh token as rotated and issues a new pair in the same family, so that
+-- if a rotated refresh token is used again the whole family can be revoked.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family text;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS rotated_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family) WHERE family IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Revisions are the movie's audit trail, so they are kept when the purge job
+-- permanently deletes the movie from the trash, rather than cascading with it.
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_movie_id_fkey;